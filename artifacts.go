@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+)
+
+// Maximum number of days Github keeps artifacts, in private repositories
+const maxArtifactRetentionDays = 400
+
+func (p *Pipeline) checkArtifactRetention() error {
+	days := p.Settings.ArtifactRetentionDays
+	if days < 0 || days > maxArtifactRetentionDays {
+		return fmt.Errorf("invalid artifact retention: %d days. It must be between 1 and %d", days, maxArtifactRetentionDays)
+	}
+	return nil
+}
+
+// Set the retention of the artifacts uploaded by the job, unless a step sets its own
+func (p *Pipeline) applyArtifactRetention(steps []JobStep) []JobStep {
+	days := p.Settings.ArtifactRetentionDays
+	if days == 0 {
+		return steps
+	}
+	for i, step := range steps {
+		if !strings.HasPrefix(step.Uses, "actions/upload-artifact@") {
+			continue
+		}
+		if _, ok := step.With["retention-days"]; ok {
+			continue
+		}
+		// Don't modify inputs shared with other steps
+		with := maps.Clone(step.With)
+		if with == nil {
+			with = map[string]string{}
+		}
+		with["retention-days"] = strconv.Itoa(days)
+		steps[i].With = with
+	}
+	return steps
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A job of a pipeline's workflow which calls a reusable workflow
+type WorkflowCall struct {
+	// Name of the job
+	Name string
+	// Reusable workflow to call
+	Uses string
+	// Inputs of the reusable workflow, in the form KEY=VALUE
+	With []string
+	// Secrets passed to the reusable workflow, in the form NAME or NAME=GITHUB_SECRET
+	Secrets []string
+	// Pass all the caller's secrets
+	InheritSecrets bool
+	// Run after the pipeline's job
+	After bool
+}
+
+// Call a reusable workflow from the workflow of a pipeline, as an additional job.
+// For example, to share deployment workflows across an organization.
+// See https://docs.github.com/en/actions/sharing-automations/reusing-workflows#calling-a-reusable-workflow
+func (m *Gha) WithWorkflowCall(
+	// Name of the pipeline whose workflow calls the reusable workflow
+	pipeline string,
+	// Name of the job
+	name string,
+	// Reusable workflow to call
+	// Example: "my-org/shared-workflows/.github/workflows/deploy.yml@v1"
+	uses string,
+	// Inputs of the reusable workflow, in the form KEY=VALUE.
+	// Values are typed as in YAML, so booleans and numbers can be passed to typed inputs.
+	// Quote a value to pass it as a string: KEY='true'
+	// +optional
+	with []string,
+	// Secrets passed to the reusable workflow, in the form NAME to pass a secret under the same name,
+	// or NAME=GITHUB_SECRET to pass a Github secret under another name
+	// +optional
+	secrets []string,
+	// Pass all the caller's secrets to the reusable workflow, with 'secrets: inherit'.
+	// This only works for reusable workflows in the same organization or enterprise
+	// +optional
+	inheritSecrets bool,
+	// Run after the pipeline's job, instead of in parallel
+	// +optional
+	after bool,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	if p.Workflow != "" {
+		return m, fmt.Errorf("pipeline '%s' is a job of the workflow of pipeline '%s'", pipeline, p.Workflow)
+	}
+	if name == "" {
+		return m, fmt.Errorf("a workflow call must have a name")
+	}
+	if !strings.Contains(uses, ".github/workflows/") {
+		return m, fmt.Errorf("invalid reusable workflow: '%s'. Expected a path like 'owner/repo/.github/workflows/file.yml@ref' or './.github/workflows/file.yml'", uses)
+	}
+	if !strings.HasPrefix(uses, "./") && !strings.Contains(uses, "@") {
+		return m, fmt.Errorf("invalid reusable workflow: '%s'. Workflows in other repositories must be pinned to a ref", uses)
+	}
+	if inheritSecrets && len(secrets) > 0 {
+		return m, fmt.Errorf("secrets can't be both inherited and passed explicitly")
+	}
+	if _, err := callInputs(with); err != nil {
+		return m, fmt.Errorf("invalid input: %w", err)
+	}
+	call := WorkflowCall{
+		Name:           name,
+		Uses:           uses,
+		With:           with,
+		Secrets:        secrets,
+		InheritSecrets: inheritSecrets,
+		After:          after,
+	}
+	jobs := append([]*Pipeline{p}, m.workflowJobs(p)...)
+	for _, job := range jobs {
+		if job.jobIDFor(pipeline) == call.jobID() {
+			return m, fmt.Errorf("workflow call '%s' conflicts with pipeline '%s': both generate the job ID '%s'. Please rename one of them",
+				name, job.Name, call.jobID())
+		}
+	}
+	for _, other := range p.WorkflowCalls {
+		if other.jobID() == call.jobID() {
+			return m, fmt.Errorf("workflow call '%s' conflicts with workflow call '%s': both generate the job ID '%s'. Please rename one of them",
+				name, other.Name, call.jobID())
+		}
+	}
+	p.WorkflowCalls = append(p.WorkflowCalls, call)
+	return m, nil
+}
+
+func (call WorkflowCall) jobID() string {
+	return (&Pipeline{Name: call.Name}).jobIDFor("")
+}
+
+func (call WorkflowCall) job(p *Pipeline) Job {
+	job := Job{
+		Name: call.Name,
+		Uses: call.Uses,
+	}
+	if len(call.With) > 0 {
+		// Inputs are validated when added
+		job.With, _ = callInputs(call.With)
+	}
+	if call.InheritSecrets {
+		job.Secrets = "inherit"
+	} else if len(call.Secrets) > 0 {
+		secrets := map[string]string{}
+		for _, secret := range call.Secrets {
+			name, githubSecret, ok := strings.Cut(secret, "=")
+			if !ok {
+				githubSecret = p.githubSecret(name)
+			}
+			secrets[name] = fmt.Sprintf("${{ secrets.%s }}", githubSecret)
+		}
+		job.Secrets = secrets
+	}
+	if call.After {
+		job.Needs = []string{p.jobID()}
+	}
+	return job
+}
+
+// Parse the inputs of a workflow call, in the form KEY=VALUE.
+// Booleans and numbers are typed, other values are passed as strings.
+func callInputs(with []string) (map[string]any, error) {
+	env, err := parseEnv(with)
+	if err != nil {
+		return nil, err
+	}
+	inputs := make(map[string]any, len(env))
+	for key, value := range env {
+		inputs[key] = value
+		var node yaml.Node
+		if err := yaml.Unmarshal([]byte(value), &node); err != nil || len(node.Content) != 1 {
+			continue
+		}
+		scalar := node.Content[0]
+		if scalar.Kind != yaml.ScalarNode {
+			continue
+		}
+		switch scalar.Tag {
+		case "!!bool", "!!int", "!!float":
+			var typed any
+			if err := scalar.Decode(&typed); err == nil {
+				inputs[key] = typed
+			}
+		case "!!str":
+			inputs[key] = scalar.Value
+		}
+	}
+	return inputs, nil
+}
+
+// A secret of a pipeline emitted as a reusable workflow
+type CallSecret struct {
+	Name        string
+	Description string
+	Optional    bool
+}
+
+// Declare a secret of a pipeline emitted as a reusable workflow.
+// The secret is injected in the pipeline like its other secrets.
+// Callers pass it explicitly, or with 'secrets: inherit'.
+func (m *Gha) WithWorkflowCallSecret(
+	// Name of the pipeline
+	pipeline string,
+	// Name of the secret
+	name string,
+	// Description of the secret
+	// +optional
+	description string,
+	// Don't require callers to pass the secret
+	// +optional
+	optional bool,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	p.OnWorkflowCall()
+	if !slices.Contains(p.Secrets, name) {
+		p.Secrets = append(p.Secrets, name)
+	}
+	p.CallSecrets = append(p.CallSecrets, CallSecret{
+		Name:        name,
+		Description: description,
+		Optional:    optional,
+	})
+	if err := p.checkSecretNames(); err != nil {
+		return m, err
+	}
+	return m, nil
+}
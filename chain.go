@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Run a pipeline after another pipeline succeeds, for example to deploy after a build.
+// The pipeline is triggered by the upstream pipeline's workflow with a 'workflow_run' event,
+// and checks out the same commit.
+// Note that Github only triggers 'workflow_run' events for workflows on the default branch.
+func (m *Gha) WithPipelineAfter(
+	// Name of the pipeline to run
+	pipeline string,
+	// Name of the pipeline which must succeed first
+	after string,
+	// Only run after the upstream pipeline ran on these branches
+	// +optional
+	branches []string,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	upstream := m.pipeline(after)
+	if upstream == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", after)
+	}
+	if upstream == p {
+		return m, fmt.Errorf("pipeline '%s' can't run after itself", pipeline)
+	}
+	p.After = appendUnique(p.After, after)
+	p.Triggers.merge(WorkflowTriggers{
+		WorkflowRun: &WorkflowRunEvent{
+			// workflow_run refers to workflows by name
+			Workflows: []string{upstream.Name},
+			Types:     []string{"completed"},
+			Branches:  branches,
+		},
+	})
+	return m, nil
+}
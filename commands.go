@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/shykes/gha/internal/dagger"
+)
+
+// A dagger call run after the command of a pipeline, in the same job
+type DaggerCall struct {
+	// Dagger command, like the pipeline's command
+	Command string
+	// Module of the command. Defaults to the pipeline's module
+	Module string
+}
+
+// Run another dagger command after the pipeline's command, in the same job, sharing the engine
+// and its warm-up. The command can load a different module, for example to call a build module,
+// then a deploy module. Commands run in order, and stop at the first failure.
+func (m *Gha) WithCall(
+	// Name of the pipeline
+	pipeline string,
+	// The Dagger command to execute
+	// Example: "deploy --image=$IMAGE"
+	command string,
+	// The Dagger module to load. Defaults to the pipeline's module
+	// +optional
+	module string,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	if strings.Contains(module, "'") {
+		return m, fmt.Errorf("invalid module: '%s'", module)
+	}
+	p.Calls = append(p.Calls, DaggerCall{Command: command, Module: module})
+	return m, nil
+}
+
+// Return the shell command running the pipeline's dagger calls
+func (p *Pipeline) daggerCommand() string {
+	commands := p.commands()
+	command := "dagger call -q " + commands[0]
+	for i, call := range p.Calls {
+		command += " && dagger call -q "
+		if call.Module != "" {
+			command += "-m '" + call.Module + "' "
+		}
+		command += commands[i+1]
+	}
+	return command
+}
+
+// Return all the dagger commands of the pipeline, as written
+func (p *Pipeline) rawCommands() []string {
+	commands := []string{p.Command}
+	for _, call := range p.Calls {
+		commands = append(commands, call.Command)
+	}
+	return commands
+}
+
+// Return all the dagger commands of the pipeline, with their templates resolved
+func (p *Pipeline) commands() []string {
+	commands := p.rawCommands()
+	for i, command := range commands {
+		// Templates are validated by check(): invalid ones are left as-is
+		commands[i], _ = p.expandCommand(command)
+	}
+	return commands
+}
+
+// Check that the additional calls of the pipeline are valid
+func (p *Pipeline) checkCalls(ctx context.Context, ctr *dagger.Container) error {
+	for _, call := range p.Calls {
+		module := call.Module
+		if module == "" {
+			module = p.Module
+		}
+		script := "dagger call"
+		if module != "" {
+			script = script + " -m '" + module + "' "
+		}
+		command, _ := p.expandCommand(call.Command)
+		script = script + command + " --help"
+		_, err := ctr.
+			WithExec(
+				[]string{"bash", "-c", script},
+				dagger.ContainerWithExecOpts{ExperimentalPrivilegedNesting: true},
+			).
+			Sync(ctx)
+		if err != nil {
+			return fmt.Errorf("call '%s': %w", call.Command, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Serialize the runs of a deploy pipeline which target the same environment, across all workflows.
+// Runs are queued rather than cancelled, since cancelling a half-finished deploy is dangerous.
+// Note that Github only keeps the latest pending run in the queue.
+func (m *Gha) WithDeployConcurrency(
+	// Name of the pipeline
+	pipeline string,
+	// Name of the target environment, or an expression which evaluates to it.
+	// When it evaluates to an empty string, runs are serialized per workflow and ref
+	// +optional
+	// +default="${{ inputs.environment }}"
+	environment string,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	p.ConcurrencyGroup = "deploy-" + deployConcurrencyKey(environment)
+	p.CancelInProgress = false
+	return m, nil
+}
+
+// Return the concurrency key of a deploy environment.
+// Runs without an environment, like those triggered by a push, fall back to their workflow and ref
+func deployConcurrencyKey(environment string) string {
+	const fallback = "format('{0}-{1}', github.workflow, github.ref)"
+	trimmed := strings.TrimSpace(environment)
+	if strings.HasPrefix(trimmed, "${{") && strings.HasSuffix(trimmed, "}}") && strings.Count(trimmed, "${{") == 1 {
+		expr := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(trimmed, "${{"), "}}"))
+		return fmt.Sprintf("${{ (%s) || %s }}", expr, fallback)
+	}
+	if environment == "" {
+		return "${{ " + fallback + " }}"
+	}
+	return environment
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// A container to run a pipeline's job in
+type JobContainerSettings struct {
+	Image string
+	// Username for the registry
+	Username string
+	// Name of the Github secret holding the registry password
+	PasswordSecret string
+	// Docker options
+	Options string
+	// Env variables in the form KEY=VALUE
+	Env []string
+	// Volumes to mount, in the form SOURCE:TARGET
+	Volumes []string
+}
+
+// Run a pipeline's job in a container, rather than directly on the runner.
+// Dagger needs access to a container runtime: for example mount the docker socket
+// with the volume "/var/run/docker.sock:/var/run/docker.sock".
+func (m *Gha) WithContainer(
+	// Name of the pipeline
+	pipeline string,
+	// Image of the container
+	// Example: "ghcr.io/my-org/ci-base:2024"
+	image string,
+	// Username to pull the image
+	// +optional
+	username string,
+	// Name of the Github secret holding the password to pull the image
+	// Example: "REGISTRY_PASSWORD"
+	// +optional
+	passwordSecret string,
+	// Additional docker options
+	// Example: "--cpus 4"
+	// +optional
+	options string,
+	// Env variables of the container, in the form KEY=VALUE
+	// +optional
+	env []string,
+	// Volumes to mount in the container, in the form SOURCE:TARGET
+	// Example: ["/var/run/docker.sock:/var/run/docker.sock"]
+	// +optional
+	volumes []string,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	if image == "" {
+		return m, fmt.Errorf("container: image is required")
+	}
+	if passwordSecret != "" && !regexp.MustCompile(`^[a-zA-Z0-9_]+$`).MatchString(passwordSecret) {
+		return m, fmt.Errorf("invalid secret name: '%s' must contain only alphanumeric characters and underscores", passwordSecret)
+	}
+	if _, err := parseEnv(env); err != nil {
+		return m, fmt.Errorf("container: %w", err)
+	}
+	p.Container = &JobContainerSettings{
+		Image:          image,
+		Username:       username,
+		PasswordSecret: passwordSecret,
+		Options:        options,
+		Env:            env,
+		Volumes:        volumes,
+	}
+	return m, nil
+}
+
+// Return the container of the pipeline's job, if any
+func (p *Pipeline) jobContainer() *JobContainer {
+	settings := p.Container
+	if settings == nil {
+		return nil
+	}
+	container := &JobContainer{
+		Image:   settings.Image,
+		Options: settings.Options,
+		Volumes: settings.Volumes,
+	}
+	if env, _ := parseEnv(settings.Env); len(env) > 0 {
+		container.Env = env
+	}
+	if settings.Username != "" || settings.PasswordSecret != "" {
+		container.Credentials = &JobContainerCredentials{Username: settings.Username}
+		if settings.PasswordSecret != "" {
+			container.Credentials.Password = fmt.Sprintf("${{ secrets.%s }}", p.githubSecret(settings.PasswordSecret))
+		}
+	}
+	return container
+}
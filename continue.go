@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Allow a pipeline's job to fail without failing the workflow
+func (m *Gha) WithContinueOnError(
+	// Name of the pipeline
+	pipeline string,
+	// "true", "false", or an expression
+	// Example: "${{ matrix.experimental }}"
+	// +optional
+	// +default="true"
+	value string,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	if _, err := strconv.ParseBool(value); err != nil && !strings.HasPrefix(strings.TrimSpace(value), "${{") {
+		return m, fmt.Errorf("invalid continue-on-error value '%s': must be a boolean or an expression", value)
+	}
+	p.ContinueOnError = value
+	return m, nil
+}
+
+// Return the continue-on-error value of the job, encoded as a boolean unless it's an expression
+func (p *Pipeline) jobContinueOnError() any {
+	if p.ContinueOnError == "" {
+		return nil
+	}
+	if b, err := strconv.ParseBool(p.ContinueOnError); err == nil {
+		if !b {
+			return nil
+		}
+		return b
+	}
+	return p.ContinueOnError
+}
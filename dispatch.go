@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/shykes/gha/internal/dagger"
+)
+
+// A repository_dispatch event sent to another repository, after a successful dagger call
+type RepositoryDispatch struct {
+	Repository    string
+	EventType     string
+	TokenSecret   string
+	ClientPayload string
+}
+
+// Send a repository_dispatch event to another repository, after a pipeline's dagger call succeeds.
+// This allows fanning out to pipelines in other repositories.
+func (m *Gha) WithRepositoryDispatch(
+	// Name of the pipeline
+	pipeline string,
+	// Repository to send the event to
+	// Example: "my-org/deployments"
+	repository string,
+	// Type of the event, to filter on in the receiving workflow
+	// Example: "release-published"
+	eventType string,
+	// Name of the Github secret holding a token with write access to the repository
+	// Example: "DISPATCH_TOKEN"
+	tokenSecret string,
+	// JSON payload of the event. Defaults to the ref and commit of the pipeline run
+	// Example: '{"version": "${{ github.ref_name }}"}'
+	// +optional
+	clientPayload string,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	if !regexp.MustCompile(`^[a-zA-Z0-9_.-]+/[a-zA-Z0-9_.-]+$`).MatchString(repository) {
+		return m, fmt.Errorf("invalid repository: '%s' must be in the form OWNER/NAME", repository)
+	}
+	if !regexp.MustCompile(`^[a-zA-Z0-9_]+$`).MatchString(tokenSecret) {
+		return m, fmt.Errorf("invalid secret name: '%s' must contain only alphanumeric characters and underscores", tokenSecret)
+	}
+	if clientPayload == "" {
+		clientPayload = `{"ref": "${{ github.ref }}", "sha": "${{ github.sha }}"}`
+	} else if !json.Valid([]byte(clientPayload)) {
+		return m, fmt.Errorf("invalid client payload: must be valid JSON")
+	}
+	p.RepositoryDispatches = append(p.RepositoryDispatches, RepositoryDispatch{
+		Repository:    repository,
+		EventType:     eventType,
+		TokenSecret:   tokenSecret,
+		ClientPayload: clientPayload,
+	})
+	return m, nil
+}
+
+func (p *Pipeline) repositoryDispatchStep(dispatch RepositoryDispatch) JobStep {
+	step := p.bashStep("repository-dispatch", map[string]string{
+		"DISPATCH_REPOSITORY":     dispatch.Repository,
+		"DISPATCH_EVENT_TYPE":     dispatch.EventType,
+		"DISPATCH_CLIENT_PAYLOAD": dispatch.ClientPayload,
+		"DISPATCH_TOKEN":          fmt.Sprintf("${{ secrets.%s }}", p.githubSecret(dispatch.TokenSecret)),
+	})
+	// There may be several dispatch steps in the same job
+	step.ID = ""
+	step.Name = fmt.Sprintf("Dispatch %s to %s", dispatch.EventType, dispatch.Repository)
+	step.If = "success()"
+	return step
+}
+
+// Trigger a manually dispatched pipeline, with the Github API
+func (m *Gha) Dispatch(
+	ctx context.Context,
+	// Name of the pipeline
+	name string,
+	// Github token with write access to the repository's actions
+	token *dagger.Secret,
+	// Repository of the workflow
+	// Example: "my-org/my-repo"
+	repository string,
+	// Inputs of the pipeline, encoded as a JSON object
+	// Example: '{"environment": "staging"}'
+	// +optional
+	inputsJson string,
+	// Git branch or tag to run the workflow on. Defaults to the default branch
+	// +optional
+	ref string,
+) error {
+	p := m.pipeline(name)
+	if p == nil {
+		return fmt.Errorf("no such pipeline: '%s'", name)
+	}
+	if p.Workflow != "" {
+		return fmt.Errorf("pipeline '%s' is a job of the workflow of pipeline '%s'", name, p.Workflow)
+	}
+	if p.Triggers.WorkflowDispatch == nil {
+		return fmt.Errorf("pipeline '%s' can't be dispatched manually", name)
+	}
+	inputs := map[string]any{}
+	if inputsJson != "" {
+		if err := json.Unmarshal([]byte(inputsJson), &inputs); err != nil {
+			return fmt.Errorf("invalid inputs: %w", err)
+		}
+	}
+	for input := range inputs {
+		if !slices.ContainsFunc(p.DispatchInputs, func(i PipelineInput) bool { return i.Name == input }) {
+			return fmt.Errorf("pipeline '%s' has no input '%s'", name, input)
+		}
+	}
+	for _, input := range p.DispatchInputs {
+		if _, ok := inputs[input.Name]; input.Required && input.Default == "" && !ok {
+			return fmt.Errorf("pipeline '%s': input '%s' is required", name, input.Name)
+		}
+	}
+	if ref == "" {
+		ref = p.Settings.defaultBranch()
+	}
+	payload, err := json.Marshal(map[string]any{"ref": ref, "inputs": inputs})
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("https://api.github.com/repos/%s/actions/workflows/%s/dispatches", repository, p.workflowFilename())
+	_, err = githubAPIContainer(token).
+		// Each call dispatches a new run: don't cache it
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithNewFile("/payload.json", string(payload)).
+		WithExec([]string{"sh", "-c",
+			`curl -fsS -X POST ` +
+				githubAPIHeaders + ` -d @/payload.json "$0"`,
+			url,
+		}).
+		Sync(ctx)
+	return err
+}
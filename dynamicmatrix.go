@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// A matrix read from a file of the repository when the workflow runs
+type DynamicMatrix struct {
+	// Path of a JSON or YAML file in the repository
+	File string
+	// jq filter selecting the matrix in the file
+	Query string
+	// Matrix key of the values, if the file contains a list
+	Key string
+}
+
+// Job ID of the job reading a dynamic matrix
+const prepareMatrixJobID = "prepare-matrix"
+
+// Run a pipeline for each entry of a matrix read from a file of the repository, when the workflow runs.
+// A preparatory job reads the file, and the pipeline's job uses 'strategy.matrix: ${{ fromJSON(...) }}',
+// so the matrix can change without regenerating the workflow.
+// The file must contain a matrix object, like '{"service": ["api", "web"]}', or a list.
+// A list of objects is used as the matrix's 'include', and a list of values as the values of the given key.
+func (m *Gha) WithDynamicMatrix(
+	// Name of the pipeline
+	pipeline string,
+	// Path of a JSON or YAML file in the repository
+	// Example: "ci/services.json"
+	file string,
+	// jq filter selecting the matrix in the file
+	// Example: ".services"
+	// +optional
+	// +default="."
+	query string,
+	// Matrix key of the values, if the matrix is a list of values
+	// Example: "service"
+	// +optional
+	key string,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	if len(p.Matrix) > 0 {
+		return m, fmt.Errorf("pipeline '%s' already has a static matrix", pipeline)
+	}
+	if p.Workflow != "" {
+		return m, fmt.Errorf("pipeline '%s' is a job of the workflow of pipeline '%s'", pipeline, p.Workflow)
+	}
+	if path.IsAbs(file) || strings.HasPrefix(path.Clean(file), "..") {
+		return m, fmt.Errorf("invalid matrix file: '%s'. It must be relative to the repository", file)
+	}
+	p.DynamicMatrix = &DynamicMatrix{
+		File:  file,
+		Query: query,
+		Key:   key,
+	}
+	return m, nil
+}
+
+// Return the matrix expression of the pipeline's job
+func (p *Pipeline) dynamicMatrixExpression() string {
+	return fmt.Sprintf("${{ fromJSON(needs.%s.outputs.matrix) }}", prepareMatrixJobID)
+}
+
+// Read the dynamic matrix of a pipeline in a preparatory job
+func (p *Pipeline) withDynamicMatrix(workflow Workflow) Workflow {
+	matrix := p.DynamicMatrix
+	if matrix == nil {
+		return workflow
+	}
+	job := workflow.Jobs[p.jobID()]
+	job.Strategy = &Strategy{Matrix: p.dynamicMatrixExpression()}
+	job.Needs = append(job.Needs, prepareMatrixJobID)
+	workflow.Jobs[p.jobID()] = job
+	// The preparatory job has no matrix, so it can't use a runner picked by the matrix
+	runner := p.Settings.Runner
+	if strings.Contains(strings.Join(runner, " "), "matrix.") {
+		runner = []string{"ubuntu-latest"}
+	}
+	workflow.Jobs[prepareMatrixJobID] = Job{
+		Name:   p.Name + " (prepare matrix)",
+		RunsOn: runner,
+		If:     job.If,
+		Steps: []JobStep{
+			{
+				Name: "Checkout",
+				Uses: "actions/checkout@v4",
+				With: map[string]string{
+					"sparse-checkout":           matrix.File,
+					"sparse-checkout-cone-mode": "false",
+				},
+			},
+			p.bashStep("read-matrix", map[string]string{
+				"MATRIX_FILE":  matrix.File,
+				"MATRIX_QUERY": matrix.Query,
+				"MATRIX_KEY":   matrix.Key,
+			}),
+		},
+		Outputs: map[string]string{"matrix": "${{ steps.read-matrix.outputs.matrix }}"},
+	}
+	return workflow
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// Github fails or truncates expressions and env values beyond this length
+	maxEnvValueLength = 21000
+	// Linux limits the total size of a process's arguments and environment (ARG_MAX),
+	// to 2 MiB with the default stack size
+	maxEnvSize = 2 * 1024 * 1024
+	// Env variable listing the values split into parts, as NAME:PARTS.
+	// The exec script joins the parts back.
+	splitEnvVariable = "DAGGER_SPLIT_ENV"
+)
+
+// Parse env variables in the form KEY=VALUE.
+// Later values override earlier values with the same key.
+func parseEnv(env []string) (map[string]string, error) {
+	result := make(map[string]string, len(env))
+	for _, kv := range env {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid env variable '%s': must be in the form KEY=VALUE", kv)
+		}
+		result[key] = value
+	}
+	return result, nil
+}
+
+// Return the env variables to set on the job
+func (p *Pipeline) jobEnv() map[string]string {
+	env, err := parseEnv(p.Settings.Env)
+	if err != nil {
+		// Invalid variables are reported by Check
+		return nil
+	}
+	if len(env) == 0 {
+		return nil
+	}
+	return env
+}
+
+// Split env values longer than Github's limit into several variables, each short enough.
+// Values are passed in env, never in the script, so expressions are still evaluated safely.
+func splitLargeEnv(env map[string]string) map[string]string {
+	result := make(map[string]string, len(env))
+	var split []string
+	for _, name := range sortedKeys(env) {
+		parts := splitEnvValue(env[name], maxEnvValueLength)
+		if len(parts) == 1 {
+			result[name] = env[name]
+			continue
+		}
+		for i, part := range parts {
+			result[envPartName(name, i+1)] = part
+		}
+		split = append(split, fmt.Sprintf("%s:%d", name, len(parts)))
+	}
+	if len(split) > 0 {
+		result[splitEnvVariable] = strings.Join(split, " ")
+	}
+	return result
+}
+
+// Name of a part of a split env value. The exec script uses the same convention.
+func envPartName(name string, part int) string {
+	return fmt.Sprintf("%s_DAGGER_PART_%d", name, part)
+}
+
+// Split a value into parts no longer than the limit.
+// Expressions are never cut, since Github evaluates them in each part separately.
+// An expression longer than the limit can't be split, and is left as is.
+func splitEnvValue(value string, limit int) []string {
+	var parts []string
+	for len(value) > limit {
+		cut := limit
+		// Don't cut an expression which starts before the cut and ends after it
+		if start := strings.LastIndex(value[:min(cut+2, len(value))], "${{"); start >= 0 && start < cut {
+			if end := strings.Index(value[start:], "}}"); end < 0 || start+end+2 > cut {
+				cut = start
+			}
+		}
+		// Don't cut a multi-byte character
+		for cut > 0 && !utf8.RuneStart(value[cut]) {
+			cut--
+		}
+		if cut == 0 {
+			break
+		}
+		parts = append(parts, value[:cut])
+		value = value[cut:]
+	}
+	return append(parts, value)
+}
+
+// Return the total size of env variables, as counted by Github
+func envSize(env map[string]string) int {
+	var size int
+	for name, value := range env {
+		size += len(name) + len(value)
+	}
+	return size
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Pass the secrets and configuration variables of the pipeline in bulk, as JSON objects.
+// The generated YAML only lists their names: all the repository's secrets and variables
+// are passed to the exec step with toJSON, and the exec script keeps the declared ones.
+// It writes them to an ephemeral env file, sources it, and deletes it.
+func (p *Pipeline) envFileEnv(env map[string]string) {
+	var spec []string
+	for _, name := range p.Secrets {
+		if source := p.githubSecret(name); source != name {
+			spec = append(spec, fmt.Sprintf("secrets %s %s", name, source))
+		} else {
+			spec = append(spec, "secrets "+name)
+		}
+	}
+	for _, name := range p.Vars {
+		spec = append(spec, "vars "+name)
+	}
+	if len(spec) == 0 {
+		return
+	}
+	env["DAGGER_ENV_FILE_SPEC"] = strings.Join(spec, "\n")
+	if len(p.Secrets) > 0 {
+		env["DAGGER_SECRETS_JSON"] = "${{ toJSON(secrets) }}"
+	}
+	if len(p.Vars) > 0 {
+		env["DAGGER_VARS_JSON"] = "${{ toJSON(vars) }}"
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/shykes/gha/internal/dagger"
+)
+
+// Headers of Github API requests, authenticated with $GITHUB_TOKEN
+const githubAPIHeaders = `-H "Accept: application/vnd.github+json" ` +
+	`-H "Authorization: Bearer $GITHUB_TOKEN" ` +
+	`-H "X-GitHub-Api-Version: 2022-11-28"`
+
+// Return a container to call the Github API with curl
+func githubAPIContainer(token *dagger.Secret) *dagger.Container {
+	return dag.
+		Wolfi().
+		Container(dagger.WolfiContainerOpts{
+			Packages: []string{"curl", "jq"},
+		}).
+		WithSecretVariable("GITHUB_TOKEN", token)
+}
+
+// Return the default branch of a repository, with the Github API.
+// Pass it to the constructor, so presets use it instead of "main".
+func (m *Gha) DefaultBranch(
+	ctx context.Context,
+	// Github token with read access to the repository
+	token *dagger.Secret,
+	// Repository to look up
+	// Example: "my-org/my-repo"
+	repository string,
+) (string, error) {
+	url := fmt.Sprintf("https://api.github.com/repos/%s", repository)
+	branch, err := githubAPIContainer(token).
+		WithExec([]string{"sh", "-c", `curl -fsS ` + githubAPIHeaders + ` "$0" | jq -r .default_branch`, url}).
+		Stdout(ctx)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(branch), nil
+}
+
+// Return the default branch of the repository, as configured
+func (s Settings) defaultBranch() string {
+	if s.DefaultBranch == "" {
+		return "main"
+	}
+	return s.DefaultBranch
+}
@@ -22,13 +22,13 @@ require (
 )
 
 require (
+	golang.org/x/crypto v0.27.0
 	golang.org/x/mod v0.20.0
 	mvdan.cc/sh v2.6.4+incompatible
 )
 
 require (
 	github.com/sergi/go-diff v1.3.2-0.20230802210424-5b0b94c5c0d3 // indirect
-	golang.org/x/crypto v0.27.0 // indirect
 	golang.org/x/term v0.24.0 // indirect
 )
 
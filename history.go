@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shykes/gha/internal/dagger"
+)
+
+// A workflow run, as returned by the Github API
+type workflowRun struct {
+	HeadSha      string    `json:"head_sha"`
+	Conclusion   string    `json:"conclusion"`
+	RunStartedAt time.Time `json:"run_started_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Workflow runs of a workflow, as returned by the Github API
+type workflowRuns struct {
+	TotalCount   int           `json:"total_count"`
+	WorkflowRuns []workflowRun `json:"workflow_runs"`
+}
+
+// Statistics of the runs of a pipeline
+type pipelineStats struct {
+	name      string
+	runs      int
+	successes int
+	duration  time.Duration
+	// Commits which both failed and succeeded
+	flakes int
+}
+
+// Report the pass rate, average duration and flakiness of the generated workflows,
+// from their run history in the Github API. The report is formatted as markdown.
+func (m *Gha) Report(
+	ctx context.Context,
+	// Github token with read access to the repository's actions
+	token *dagger.Secret,
+	// Repository of the workflows
+	// Example: "my-org/my-repo"
+	repository string,
+	// Only include runs created since this date. Defaults to the last 30 days
+	// Example: "2024-01-31"
+	// +optional
+	since string,
+	// Only include pipelines with at least one of these tags
+	// +optional
+	onlyTags []string,
+) (string, error) {
+	if since == "" {
+		since = time.Now().AddDate(0, 0, -30).Format(time.DateOnly)
+	} else if _, err := time.Parse(time.DateOnly, since); err != nil {
+		return "", fmt.Errorf("invalid date: '%s': must be in the form YYYY-MM-DD", since)
+	}
+	ctr := githubAPIContainer(token).
+		// The run history changes constantly: don't cache it
+		WithEnvVariable("CACHE_BUSTER", time.Now().String())
+	var stats []pipelineStats
+	for _, p := range m.workflowPipelines(m.selectPipelines(onlyTags)) {
+		if p.Workflow != "" {
+			continue
+		}
+		url := fmt.Sprintf("https://api.github.com/repos/%s/actions/workflows/%s/runs?status=completed&per_page=100&created=%%3E%%3D%s",
+			repository, p.workflowFilename(), since)
+		// Fetch every page, until a page isn't full.
+		// The API returns at most 1000 runs for a filtered query: stop after 10 pages
+		out, err := ctr.
+			WithExec([]string{"sh", "-c",
+				`set -e; pages=$(mktemp -d); page=1
+				while :; do
+					curl -fsS ` + githubAPIHeaders + ` "$0&page=$page" > "$pages/$page.json"
+					[ "$(jq '.workflow_runs | length' "$pages/$page.json")" -lt 100 ] && break
+					[ "$page" -ge 10 ] && break
+					page=$((page + 1))
+				done
+				jq -cs '{total_count: .[0].total_count, workflow_runs: map(.workflow_runs[])}' "$pages"/*.json`,
+				url,
+			}).
+			Stdout(ctx)
+		if err != nil {
+			return "", fmt.Errorf("pipeline '%s': %w", p.Name, err)
+		}
+		var runs workflowRuns
+		if err := json.Unmarshal([]byte(out), &runs); err != nil {
+			return "", fmt.Errorf("pipeline '%s': %w", p.Name, err)
+		}
+		if runs.TotalCount > len(runs.WorkflowRuns) {
+			fmt.Fprintf(os.Stderr, "warning: pipeline '%s': only %d of %d runs were fetched. Use a more recent date\n",
+				p.Name, len(runs.WorkflowRuns), runs.TotalCount)
+		}
+		stats = append(stats, runStats(p.Name, runs.WorkflowRuns))
+	}
+	return formatReport(stats, since), nil
+}
+
+func runStats(name string, runs []workflowRun) pipelineStats {
+	stats := pipelineStats{name: name}
+	conclusions := map[string][]string{}
+	for _, run := range runs {
+		switch run.Conclusion {
+		case "success", "failure":
+		default:
+			// Cancelled and skipped runs say nothing about the pipeline
+			continue
+		}
+		stats.runs++
+		if run.Conclusion == "success" {
+			stats.successes++
+		}
+		stats.duration += run.UpdatedAt.Sub(run.RunStartedAt)
+		conclusions[run.HeadSha] = appendUnique(conclusions[run.HeadSha], run.Conclusion)
+	}
+	for _, c := range conclusions {
+		if len(c) > 1 {
+			stats.flakes++
+		}
+	}
+	return stats
+}
+
+func formatReport(stats []pipelineStats, since string) string {
+	// Flakiest pipelines first
+	sort.SliceStable(stats, func(i, j int) bool {
+		return stats[i].flakes > stats[j].flakes
+	})
+	var report strings.Builder
+	fmt.Fprintf(&report, "# Pipeline report since %s\n\n", since)
+	report.WriteString("| Pipeline | Runs | Pass rate | Average duration | Flaky commits |\n")
+	report.WriteString("|---|---|---|---|---|\n")
+	for _, s := range stats {
+		if s.runs == 0 {
+			fmt.Fprintf(&report, "| %s | 0 | - | - | - |\n", s.name)
+			continue
+		}
+		fmt.Fprintf(&report, "| %s | %d | %.0f%% | %s | %d |\n",
+			s.name,
+			s.runs,
+			100*float64(s.successes)/float64(s.runs),
+			(s.duration / time.Duration(s.runs)).Round(time.Second),
+			s.flakes)
+	}
+	return report.String()
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// An input to a pipeline.
+// Its value is available to the dagger command as env variable INPUT_<NAME>
+type PipelineInput struct {
+	Name        string
+	Description string
+	Type        string
+	Required    bool
+	Default     string
+	// Options of a "choice" input
+	Options []string
+}
+
+// Name of the env variable which holds the value of the input
+func (input PipelineInput) envName() string {
+	re := regexp.MustCompile(`[^A-Z0-9_]+`)
+	return "INPUT_" + re.ReplaceAllString(strings.ToUpper(input.Name), "_")
+}
+
+// Github expression which evaluates to the value of the input
+func (input PipelineInput) expression() string {
+	return fmt.Sprintf("${{ inputs.%s }}", input.Name)
+}
+
+// Return the default value of the input, encoded for its type
+func (input PipelineInput) typedDefault() any {
+	if input.Default == "" {
+		return nil
+	}
+	switch input.Type {
+	case "boolean":
+		if b, err := strconv.ParseBool(input.Default); err == nil {
+			return b
+		}
+	case "number":
+		if n, err := strconv.ParseFloat(input.Default, 64); err == nil {
+			return n
+		}
+	}
+	return input.Default
+}
+
+// Add an input to a manually dispatched pipeline
+func (m *Gha) WithDispatchInput(
+	// Name of the pipeline
+	pipeline string,
+	// Name of the input
+	name string,
+	// Description of the input
+	// +optional
+	description string,
+	// Default value of the input
+	// +optional
+	defaultValue string,
+	// Require a value for the input
+	// +optional
+	required bool,
+	// Type of the input
+	// Possible values: "string", "choice", "boolean", "number", "environment"
+	// +optional
+	// +default="string"
+	inputType string,
+	// Options of a "choice" input, displayed as a dropdown
+	// +optional
+	options []string,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	input := PipelineInput{
+		Name:        name,
+		Description: description,
+		Type:        inputType,
+		Required:    required,
+		Default:     defaultValue,
+		Options:     options,
+	}
+	if err := input.checkDispatch(); err != nil {
+		return m, err
+	}
+	if p.Triggers.WorkflowDispatch == nil {
+		p.Triggers.WorkflowDispatch = &WorkflowDispatchEvent{}
+	}
+	p.DispatchInputs = append(p.DispatchInputs, input)
+	return m, nil
+}
+
+// Check that a workflow_dispatch input is valid
+func (input PipelineInput) checkDispatch() error {
+	switch input.Type {
+	case "string", "environment":
+	case "choice":
+		if len(input.Options) == 0 {
+			return fmt.Errorf("input '%s': choice inputs require options", input.Name)
+		}
+		if input.Default != "" && !slices.Contains(input.Options, input.Default) {
+			return fmt.Errorf("input '%s': default value '%s' is not one of the options", input.Name, input.Default)
+		}
+	case "boolean":
+		if _, err := strconv.ParseBool(input.Default); input.Default != "" && err != nil {
+			return fmt.Errorf("input '%s': default value '%s' is not a boolean", input.Name, input.Default)
+		}
+	case "number":
+		if _, err := strconv.ParseFloat(input.Default, 64); input.Default != "" && err != nil {
+			return fmt.Errorf("input '%s': default value '%s' is not a number", input.Name, input.Default)
+		}
+	default:
+		return fmt.Errorf("unsupported type for input '%s': '%s'", input.Name, input.Type)
+	}
+	if input.Type != "choice" && len(input.Options) > 0 {
+		return fmt.Errorf("input '%s': only choice inputs can have options", input.Name)
+	}
+	return nil
+}
+
+// Add an input to a pipeline emitted as a reusable workflow
+func (m *Gha) WithWorkflowCallInput(
+	// Name of the pipeline
+	pipeline string,
+	// Name of the input
+	name string,
+	// Description of the input
+	// +optional
+	description string,
+	// Type of the input
+	// Possible values: "string", "number", "boolean"
+	// +optional
+	// +default="string"
+	inputType string,
+	// Require callers to pass the input
+	// +optional
+	required bool,
+	// Default value of the input
+	// +optional
+	defaultValue string,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	switch inputType {
+	case "string", "number", "boolean":
+	default:
+		return m, fmt.Errorf("unsupported type for input '%s': '%s'", name, inputType)
+	}
+	p.OnWorkflowCall()
+	p.CallInputs = append(p.CallInputs, PipelineInput{
+		Name:        name,
+		Description: description,
+		Type:        inputType,
+		Required:    required,
+		Default:     defaultValue,
+	})
+	return m, nil
+}
+
+// Allow other workflows to call this pipeline, as a reusable workflow
+func (p *Pipeline) OnWorkflowCall() *Pipeline {
+	p.Reusable = true
+	return p
+}
+
+func (p *Pipeline) workflowDispatchTrigger() *WorkflowDispatchTrigger {
+	trigger := &WorkflowDispatchTrigger{}
+	if len(p.DispatchInputs) > 0 {
+		trigger.Inputs = make(map[string]DispatchInput, len(p.DispatchInputs))
+		for _, input := range p.DispatchInputs {
+			trigger.Inputs[input.Name] = DispatchInput{
+				Description: input.Description,
+				Required:    input.Required,
+				Default:     input.typedDefault(),
+				Type:        input.Type,
+				Options:     input.Options,
+			}
+		}
+	}
+	return trigger
+}
+
+func (p *Pipeline) workflowCallEvent() *WorkflowCallEvent {
+	event := &WorkflowCallEvent{
+		Outputs: map[string]WorkflowCallOutput{
+			"stdout": {
+				Description: "Standard output of the dagger call",
+				Value:       fmt.Sprintf("${{ jobs.%s.outputs.stdout }}", p.jobID()),
+			},
+			"stderr": {
+				Description: "Standard error of the dagger call",
+				Value:       fmt.Sprintf("${{ jobs.%s.outputs.stderr }}", p.jobID()),
+			},
+		},
+	}
+	for _, output := range p.Outputs {
+		event.Outputs[output.Name] = WorkflowCallOutput{
+			Description: output.Description,
+			Value:       fmt.Sprintf("${{ jobs.%s.outputs.%s }}", p.jobID(), output.Name),
+		}
+	}
+	if len(p.CallInputs) > 0 {
+		event.Inputs = make(map[string]WorkflowCallInput, len(p.CallInputs))
+		for _, input := range p.CallInputs {
+			event.Inputs[input.Name] = WorkflowCallInput{
+				Description: input.Description,
+				Required:    input.Required,
+				Default:     input.typedDefault(),
+				Type:        input.Type,
+			}
+		}
+	}
+	// Callers must pass the secrets used by the pipeline
+	if len(p.Secrets) > 0 {
+		event.Secrets = make(map[string]WorkflowCallSecret, len(p.Secrets))
+		for _, secret := range p.Secrets {
+			event.Secrets[p.githubSecret(secret)] = WorkflowCallSecret{Required: true}
+		}
+		for _, secret := range p.CallSecrets {
+			event.Secrets[p.githubSecret(secret.Name)] = WorkflowCallSecret{
+				Description: secret.Description,
+				Required:    !secret.Optional,
+			}
+		}
+	}
+	// Callers may pass the Dagger Cloud token, to send traces
+	if !p.Settings.NoTraces && p.Settings.PublicToken == "" {
+		name := p.githubSecret("DAGGER_CLOUD_TOKEN")
+		if _, ok := event.Secrets[name]; !ok {
+			if event.Secrets == nil {
+				event.Secrets = map[string]WorkflowCallSecret{}
+			}
+			event.Secrets[name] = WorkflowCallSecret{Description: "Dagger Cloud token, to send traces"}
+		}
+	}
+	return event
+}
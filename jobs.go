@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Run a pipeline as an additional job in the workflow of another pipeline,
+// after the jobs it needs. For example: build, then test, then publish.
+// The job runs on the triggers of the workflow: its own triggers are ignored.
+func (m *Gha) WithJob(
+	// Name of the pipeline whose workflow includes the job
+	workflow string,
+	// Name of the pipeline to run as a job
+	pipeline string,
+	// Names of the pipelines which must succeed before the job starts.
+	// They must be the workflow's pipeline, or other jobs of the workflow
+	// +optional
+	needs []string,
+) (*Gha, error) {
+	parent := m.pipeline(workflow)
+	if parent == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", workflow)
+	}
+	if parent.Workflow != "" {
+		return m, fmt.Errorf("pipeline '%s' is a job of the workflow of pipeline '%s'", workflow, parent.Workflow)
+	}
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	if p == parent {
+		return m, fmt.Errorf("pipeline '%s' can't be a job of its own workflow", pipeline)
+	}
+	if p.Workflow != "" {
+		return m, fmt.Errorf("pipeline '%s' is already a job of the workflow of pipeline '%s'", pipeline, p.Workflow)
+	}
+	if len(m.workflowJobs(p)) > 0 {
+		return m, fmt.Errorf("pipeline '%s' has jobs of its own", pipeline)
+	}
+	if p.DynamicMatrix != nil {
+		// The job reading the matrix would not be part of the workflow
+		return m, fmt.Errorf("pipeline '%s' has a dynamic matrix, and can't be a job of another workflow", pipeline)
+	}
+	if p.EphemeralRunner != nil {
+		// The jobs provisioning and removing the runner would not be part of the workflow
+		return m, fmt.Errorf("pipeline '%s' runs on an ephemeral runner, and can't be a job of another workflow", pipeline)
+	}
+	jobs := append([]*Pipeline{parent}, m.workflowJobs(parent)...)
+	for _, need := range needs {
+		if !slices.ContainsFunc(jobs, func(job *Pipeline) bool { return job.Name == need }) {
+			return m, fmt.Errorf("pipeline '%s' can't need '%s': it is not a job of the workflow of pipeline '%s'", pipeline, need, workflow)
+		}
+	}
+	for _, job := range jobs {
+		if job.jobIDFor(workflow) == p.jobIDFor(workflow) {
+			return m, fmt.Errorf("pipeline '%s' conflicts with pipeline '%s': both generate the job ID '%s'. Please rename one of them",
+				pipeline, job.Name, job.jobIDFor(workflow))
+		}
+	}
+	for _, call := range parent.WorkflowCalls {
+		if call.jobID() == p.jobIDFor(workflow) {
+			return m, fmt.Errorf("pipeline '%s' conflicts with workflow call '%s': both generate the job ID '%s'. Please rename one of them",
+				pipeline, call.Name, call.jobID())
+		}
+	}
+	p.Workflow = workflow
+	p.Needs = needs
+	return m, nil
+}
+
+// Return the pipelines which run as additional jobs in the workflow of a pipeline
+func (m *Gha) workflowJobs(parent *Pipeline) []*Pipeline {
+	var jobs []*Pipeline
+	for _, p := range m.Pipelines {
+		if p.Workflow == parent.Name {
+			jobs = append(jobs, p)
+		}
+	}
+	return jobs
+}
+
+// Generate the workflow of a pipeline, including the jobs of other pipelines
+func (m *Gha) asWorkflow(parent *Pipeline) Workflow {
+	workflow := parent.asWorkflow()
+	for _, p := range m.workflowJobs(parent) {
+		job := p.asWorkflow().Jobs[p.jobID()]
+		for _, need := range p.Needs {
+			if needed := m.pipeline(need); needed != nil {
+				job.Needs = append(job.Needs, needed.jobID())
+			}
+		}
+		workflow.Jobs[p.jobID()] = job
+	}
+	for _, call := range parent.WorkflowCalls {
+		workflow.Jobs[call.jobID()] = call.job(parent)
+	}
+	return workflow
+}
+
+// Return the job ID of the pipeline, if it runs in the workflow of the given pipeline
+func (p *Pipeline) jobIDFor(workflow string) string {
+	if p.Name == workflow {
+		return "dagger"
+	}
+	id := regexp.MustCompile(`[^a-z0-9_-]+`).ReplaceAllString(strings.ToLower(p.Name), "-")
+	id = strings.Trim(id, "-")
+	// Job IDs must start with a letter or _
+	if id == "" || !regexp.MustCompile(`^[a-z_]`).MatchString(id) {
+		id = "_" + id
+	}
+	return id
+}
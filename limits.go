@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Github limits on workflows, checked at generation time so configurations are not
+// rejected opaquely when they are pushed.
+// See https://docs.github.com/en/actions/administering-github-actions/usage-limits-billing-and-administration
+const (
+	// Maximum size of a workflow file
+	maxWorkflowSize = 512 * 1024
+	// Maximum depth of nested reusable workflow calls, including the top-level caller
+	// See https://docs.github.com/en/actions/sharing-automations/reusing-workflows#limitations
+	maxWorkflowNesting = 10
+	// Maximum number of unique reusable workflows called from a workflow file
+	maxWorkflowCalls = 50
+	// Maximum number of jobs in a workflow run, including matrix combinations
+	maxWorkflowJobs = 256
+)
+
+// Check the generated workflows against Github's limits
+func (m *Gha) checkLimits(workflows []generatedWorkflow) error {
+	var errs []error
+	for _, w := range workflows {
+		if err := m.checkWorkflowLimits(w); err != nil {
+			errs = append(errs, fmt.Errorf("pipeline '%s': %w", w.pipeline.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Gha) checkWorkflowLimits(w generatedWorkflow) error {
+	p := w.pipeline
+	var (
+		contents []byte
+		err      error
+	)
+	if p.Settings.AsJson {
+		contents, err = json.MarshalIndent(w.workflow, "", " ")
+	} else {
+		contents, err = yaml.Marshal(w.workflow)
+	}
+	if err != nil {
+		return err
+	}
+	if len(contents) > maxWorkflowSize {
+		return fmt.Errorf("workflow file %s is %d KiB, beyond Github's limit of %d KiB", p.workflowFilename(), len(contents)/1024, maxWorkflowSize/1024)
+	}
+	// Each matrix combination is a separate job
+	jobs := len(w.workflow.Jobs)
+	for _, job := range append([]*Pipeline{p}, m.workflowJobs(p)...) {
+		jobs += job.matrixSize() - 1
+		if size := job.matrixSize(); size > maxMatrixJobs {
+			if len(job.MatrixExclusions) == 0 {
+				return fmt.Errorf("the matrix of pipeline '%s' generates %d jobs, beyond Github's limit of %d", job.Name, size, maxMatrixJobs)
+			}
+			fmt.Fprintf(os.Stderr,
+				"warning: pipeline '%s': the matrix generates up to %d jobs before exclusions, and Github's limit is %d\n",
+				job.Name, size, maxMatrixJobs)
+		}
+	}
+	if jobs > maxWorkflowJobs {
+		return fmt.Errorf("workflow runs up to %d jobs, beyond Github's limit of %d", jobs, maxWorkflowJobs)
+	}
+	if n := len(p.WorkflowCalls); n > maxWorkflowCalls {
+		return fmt.Errorf("workflow calls %d reusable workflows, beyond Github's limit of %d", n, maxWorkflowCalls)
+	}
+	if depth := m.workflowNesting(p, nil); depth > maxWorkflowNesting {
+		return fmt.Errorf("reusable workflows are nested %d levels deep, beyond Github's limit of %d", depth, maxWorkflowNesting)
+	}
+	return nil
+}
+
+// Return the number of jobs generated by the pipeline's matrix, before exclusions
+func (p *Pipeline) matrixSize() int {
+	if len(p.Matrix) == 0 {
+		return 1
+	}
+	size := 1
+	for _, dimension := range p.Matrix {
+		size *= len(dimension.Values)
+	}
+	return size
+}
+
+// Return the depth of nested workflow calls from the pipeline's workflow, including itself.
+// Only calls to workflows generated from other pipelines can be followed.
+func (m *Gha) workflowNesting(p *Pipeline, visited []string) int {
+	if slices.Contains(visited, p.Name) {
+		// Github rejects cycles anyway: don't loop forever
+		return len(visited) + 1
+	}
+	visited = append(visited, p.Name)
+	depth := 1
+	for _, call := range p.WorkflowCalls {
+		callee := m.calledPipeline(call.Uses)
+		if callee == nil {
+			continue
+		}
+		depth = max(depth, 1+m.workflowNesting(callee, visited))
+	}
+	return depth
+}
+
+// Return the pipeline generating a reusable workflow of this repository, if any
+func (m *Gha) calledPipeline(uses string) *Pipeline {
+	filename, ok := strings.CutPrefix(uses, "./.github/workflows/")
+	if !ok {
+		return nil
+	}
+	for _, p := range m.Pipelines {
+		if p.Workflow == "" && p.workflowFilename() == filename {
+			return p
+		}
+	}
+	return nil
+}
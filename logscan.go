@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"slices"
+	"strings"
+)
+
+// A pattern to look for in the logs of a pipeline
+type LogScan struct {
+	// Extended regular expression, matched against each line of the logs
+	Pattern string
+	// Level of the annotation reported when the pattern is found
+	Level string
+	// Minimum number of matching lines to report
+	Threshold int
+	// Fail the job when the pattern is found. Either "true", or a Github Actions expression
+	Fail string
+}
+
+// Levels of the annotations reported by log scans
+var logScanLevels = []string{"notice", "warning", "error"}
+
+// Scan the output and logs of a pipeline for a pattern, after it runs.
+// Matches are reported as annotations, and can fail the job, for example to treat
+// specific warnings as errors only on main.
+func (m *Gha) WithLogScan(
+	// Name of the pipeline
+	pipeline string,
+	// POSIX extended regular expression, as supported by 'grep -E', matched against each line of the output and logs
+	// Example: "DEPRECATED|deprecation warning"
+	pattern string,
+	// Level of the annotation reported when the pattern is found
+	// Possible values: "notice", "warning", "error"
+	// +optional
+	// +default="warning"
+	level string,
+	// Only report when at least this many lines match, to detect spikes of a common warning
+	// +optional
+	// +default=1
+	threshold int,
+	// Fail the job when the pattern is found
+	// +optional
+	fail bool,
+	// Only fail the job when this Github Actions expression is true
+	// Example: "github.ref == 'refs/heads/main'"
+	// +optional
+	failCondition string,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	if err := checkExtendedRegexp(pattern); err != nil {
+		return m, fmt.Errorf("invalid log scan pattern: %w", err)
+	}
+	if strings.Contains(pattern, "\n") {
+		return m, fmt.Errorf("invalid log scan pattern: patterns are matched line by line")
+	}
+	if !slices.Contains(logScanLevels, level) {
+		return m, fmt.Errorf("invalid log scan level: '%s'. Possible values: %s", level, strings.Join(logScanLevels, ", "))
+	}
+	if threshold < 1 {
+		return m, fmt.Errorf("invalid log scan threshold: %d", threshold)
+	}
+	scan := LogScan{
+		Pattern:   pattern,
+		Level:     level,
+		Threshold: threshold,
+		Fail:      "false",
+	}
+	switch {
+	case failCondition != "":
+		scan.Fail = "${{ " + unwrapExpression(failCondition) + " }}"
+	case fail:
+		scan.Fail = "true"
+	}
+	p.LogScans = append(p.LogScans, scan)
+	return m, nil
+}
+
+// Check that a pattern is a POSIX extended regular expression, as run by 'grep -E'.
+// Perl extensions like \d, (?i) or lazy quantifiers would be silently misinterpreted.
+func checkExtendedRegexp(pattern string) error {
+	if _, err := regexp.CompilePOSIX(pattern); err != nil {
+		return err
+	}
+	// 'a+?' is valid in both syntaxes, but only lazy in Perl syntax
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return err
+	}
+	if hasNonGreedy(re) {
+		return fmt.Errorf("lazy quantifiers are not supported by extended regular expressions")
+	}
+	return nil
+}
+
+func hasNonGreedy(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		if re.Flags&syntax.NonGreedy != 0 {
+			return true
+		}
+	}
+	return slices.ContainsFunc(re.Sub, hasNonGreedy)
+}
+
+// Path of the log file on the runner
+func (p *Pipeline) logFile() string {
+	return "${{ runner.temp }}/dagger-logs.txt"
+}
+
+func (p *Pipeline) scanLogsStep() JobStep {
+	var scans []string
+	for _, scan := range p.LogScans {
+		scans = append(scans, fmt.Sprintf("%s %s %d %s", scan.Level, scan.Fail, scan.Threshold, scan.Pattern))
+	}
+	step := p.bashStep("scan-logs", map[string]string{
+		"LOG_FILE":  p.logFile(),
+		"LOG_SCANS": strings.Join(scans, "\n"),
+	})
+	step.If = "always()"
+	return step
+}
@@ -9,15 +9,23 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
+	"path"
 	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/shykes/gha/internal/dagger"
 	"golang.org/x/mod/semver"
+	"gopkg.in/yaml.v3"
 	"mvdan.cc/sh/shell"
 )
 
@@ -25,6 +33,9 @@ func New(
 	// Disable sending traces to Dagger Cloud
 	// +optional
 	noTraces bool,
+	// Don't fail when Dagger Cloud is unreachable: run without traces instead
+	// +optional
+	cloudOptional bool,
 	// Public Dagger Cloud token, for open-source projects. DO NOT PASS YOUR PRIVATE DAGGER CLOUD TOKEN!
 	// This is for a special "public" token which can safely be shared publicly.
 	// To get one, contact support@dagger.io
@@ -40,7 +51,8 @@ func New(
 	// Encode all files as JSON (which is also valid YAML)
 	// +optional
 	asJson bool,
-	// Configure a default runner for all workflows
+	// Configure a default runner for all workflows. Multiple labels select a runner which has all of them.
+	// Example: ["self-hosted", "linux", "x64", "gpu"]
 	// See https://docs.github.com/en/actions/hosting-your-own-runners/managing-self-hosted-runners/using-self-hosted-runners-in-a-workflow
 	// +optional
 	runner []string,
@@ -52,25 +64,114 @@ func New(
 	// +optional
 	// +ignore=["!.github"]
 	repository *dagger.Directory,
-	// Default timeout for CI jobs, in minutes
+	// Default timeout for CI jobs, in minutes. Between 1 and 4320 (72 hours).
+	// By default, Github cancels jobs after 360 minutes.
 	// +optional
 	timeoutMinutes int,
-) *Gha {
+	// Generate a README listing the generated workflows, in .github/workflows/README.md
+	// +optional
+	readme bool,
+	// Command to regenerate the configuration, mentioned in the generated README
+	// Example: "dagger call -m .github generate export --path=.github"
+	// +optional
+	regenerateCommand string,
+	// Banner inserted as a comment block in every generated file. For example a license or compliance notice
+	// +optional
+	banner string,
+	// Avoid features which act can't emulate, so workflows can run locally.
+	// Service containers are started with docker instead. Other unsupported features are rejected
+	// See https://github.com/nektos/act
+	// +optional
+	actCompatible bool,
+	// Also emit a JSON copy of each workflow under this directory, for tooling which only reads JSON
+	// Example: ".github/workflows-json"
+	// +optional
+	jsonMirror string,
+	// Merge pipelines which differ only by their triggers into a single workflow.
+	// The merged workflow is named after the first pipeline.
+	// +optional
+	mergeIdentical bool,
+	// Default env variables for all jobs, in the form KEY=VALUE
+	// Example: ["CI=true", "HTTPS_PROXY=http://proxy.example.com:3128"]
+	// +optional
+	env []string,
+	// Deny all permissions by default: pipelines only get the permissions they are explicitly granted
+	// +optional
+	denyAllPermissions bool,
+	// Always install the dagger CLI and start a new engine, even if the runner image has the pinned version pre-installed or running
+	// +optional
+	forceInstall bool,
+	// Connect to a pre-existing engine, instead of starting one on the runner. Required on Windows runners.
+	// Example: "tcp://dagger-engine.internal:8080"
+	// +optional
+	engineHost string,
+	// Number of days to keep the artifacts uploaded by pipelines, between 1 and 400.
+	// Defaults to the repository's retention setting
+	// +optional
+	artifactRetentionDays int,
+	// Pass secrets and configuration variables to the pipeline through an ephemeral env file,
+	// instead of one env variable each. This keeps the generated YAML small for pipelines with many of them.
+	// All the repository's secrets and variables are exposed to the exec step, which passes only
+	// the declared ones to the pipeline. Don't use it if other secrets must stay out of the step
+	// +optional
+	envFile bool,
+	// Default branch of the repository, used by presets and by functions calling the Github API.
+	// See DefaultBranch to look it up
+	// +optional
+	// +default="main"
+	defaultBranch string,
+	// Prefix of the Github secrets injected in pipelines, for organization-wide naming conventions.
+	// For example with "ORG_", the secret API_KEY is read from the Github secret ORG_API_KEY.
+	// Secrets mapped with WithSecretMapping are read as mapped
+	// +optional
+	secretPrefix string,
+	// Suffix of the Github secrets injected in pipelines, for organization-wide naming conventions
+	// +optional
+	secretSuffix string,
+	// Configure the default runner from a preset, instead of labels
+	// Possible values: "linux-amd64", "linux-arm64", "macos-arm64", "windows-amd64", "self-hosted-amd64", "self-hosted-arm64",
+	// "linux-4-cores", "linux-8-cores", "linux-16-cores", "linux-32-cores", "linux-64-cores"
+	// +optional
+	runnerPreset string,
+) (*Gha, error) {
+	if runnerPreset != "" {
+		labels, err := runnerPresetLabels(runnerPreset)
+		if err != nil {
+			return nil, err
+		}
+		runner = labels
+	}
 	if runner == nil {
 		runner = []string{"ubuntu-latest"}
 	}
 
 	return &Gha{Settings: Settings{
-		PublicToken:    publicToken,
-		NoTraces:       noTraces,
-		DaggerVersion:  daggerVersion,
-		StopEngine:     stopEngine,
-		AsJson:         asJson,
-		Runner:         runner,
-		FileExtension:  fileExtension,
-		Repository:     repository,
-		TimeoutMinutes: timeoutMinutes,
-	}}
+		PublicToken:           publicToken,
+		NoTraces:              noTraces,
+		CloudOptional:         cloudOptional,
+		DaggerVersion:         daggerVersion,
+		StopEngine:            stopEngine,
+		AsJson:                asJson,
+		Runner:                runner,
+		FileExtension:         fileExtension,
+		Repository:            repository,
+		TimeoutMinutes:        timeoutMinutes,
+		Readme:                readme,
+		RegenerateCommand:     regenerateCommand,
+		Banner:                banner,
+		ActCompatible:         actCompatible,
+		Env:                   env,
+		JsonMirror:            jsonMirror,
+		MergeIdentical:        mergeIdentical,
+		DenyAllPermissions:    denyAllPermissions,
+		ForceInstall:          forceInstall,
+		EngineHost:            engineHost,
+		ArtifactRetentionDays: artifactRetentionDays,
+		EnvFile:               envFile,
+		DefaultBranch:         defaultBranch,
+		SecretPrefix:          secretPrefix,
+		SecretSuffix:          secretSuffix,
+	}}, nil
 }
 
 type Gha struct {
@@ -84,43 +185,138 @@ type Settings struct {
 	PublicToken            string
 	DaggerVersion          string
 	NoTraces               bool
+	CloudOptional          bool
 	StopEngine             bool
 	AsJson                 bool
 	Runner                 []string
 	PullRequestConcurrency string
+	PushConcurrency        string
 	Debug                  bool
 	FileExtension          string
 	Repository             *dagger.Directory
 	TimeoutMinutes         int
 	Permissions            Permissions
+	DenyAllPermissions     bool
+	ForceInstall           bool
+	Readme                 bool
+	RegenerateCommand      string
+	Banner                 string
+	ActCompatible          bool
+	Shell                  string
+	EngineLogLevel         string
+	CaptureEngineLogs      bool
+	EngineHost             string
+	ArtifactRetentionDays  int
+	EnvFile                bool
+	DefaultBranch          string
+	SecretPrefix           string
+	SecretSuffix           string
+	Env                    []string
+	JsonMirror             string
+	MergeIdentical         bool
+	// Github secrets to use for logical secret names, in the form NAME=SECRET
+	SecretMappings []string
+	// Tag patterns protected in the repository
+	ProtectedTags []string
 }
 
 // Validate a Github Actions configuration (best effort)
-func (m *Gha) Validate(ctx context.Context, repo *dagger.Directory) (*Gha, error) {
-	for _, p := range m.Pipelines {
-		if err := p.Check(ctx, repo); err != nil {
-			return m, err
+func (m *Gha) Validate(
+	ctx context.Context,
+	repo *dagger.Directory,
+	// Only validate pipelines with at least one of these tags
+	// +optional
+	onlyTags []string,
+	// Only validate the pipelines with these names
+	// +optional
+	onlyPipelines []string,
+) (*Gha, error) {
+	ctr, err := checkContainer(repo).Sync(ctx)
+	if err != nil {
+		return m, err
+	}
+	pipelines := slices.Clone(m.selectPipelines(onlyTags))
+	if onlyPipelines != nil {
+		pipelines = slices.DeleteFunc(pipelines, func(p *Pipeline) bool {
+			return !slices.Contains(onlyPipelines, p.Name)
+		})
+		// Jobs of another pipeline's workflow are checked with it
+		for _, p := range slices.Clone(pipelines) {
+			if parent := m.pipeline(p.Workflow); parent != nil && !slices.Contains(pipelines, parent) {
+				pipelines = append(pipelines, parent)
+			}
 		}
 	}
-	return m, nil
+	// Check all pipelines, so all errors can be fixed in one pass
+	var errs []error
+	for _, p := range pipelines {
+		if onlyPipelines != nil && !slices.Contains(onlyPipelines, p.Name) {
+			continue
+		}
+		if err := p.check(ctx, ctr); err != nil {
+			errs = append(errs, fmt.Errorf("pipeline '%s': %w", p.Name, err))
+		}
+	}
+	if err := m.checkLimits(m.generateWorkflows(pipelines)); err != nil {
+		errs = append(errs, err)
+	}
+	return m, errors.Join(errs...)
 }
 
 // Export the configuration to a .github directory
-func (m *Gha) Config(ctx context.Context) *dagger.Directory {
+func (m *Gha) Config(
+	ctx context.Context,
+	// Only generate pipelines with at least one of these tags.
+	// Existing workflows of other pipelines are left unchanged.
+	// +optional
+	onlyTags []string,
+) (*dagger.Directory, error) {
+	for _, p := range m.selectPipelines(onlyTags) {
+		if err := errors.Join(p.checkEphemeralRunner(), p.checkActCompatible()); err != nil {
+			return nil, fmt.Errorf("pipeline '%s': %w", p.Name, err)
+		}
+	}
+	// Workflows are generated once, then checked and exported
+	workflows := m.generateWorkflows(m.selectPipelines(onlyTags))
+	if err := m.checkLimits(workflows); err != nil {
+		return nil, err
+	}
 	return m.
-		otherWorkflows(ctx).
-		WithDirectory(".", m.generatedWorkflows()).
-		WithDirectory(".", m.gitAttributes(ctx))
+		otherWorkflows(ctx, onlyTags != nil).
+		WithDirectory(".", m.workflowsConfig(workflows)).
+		WithDirectory(".", m.ownership(m.Pipelines)).
+		WithDirectory(".", m.readme()).
+		WithDirectory(".", m.gitAttributes(ctx)), nil
+}
+
+// Return the generated workflows as JSON, indexed by their path in the repository.
+// Other modules can consume, transform or analyze them programmatically.
+func (m *Gha) WorkflowsJson(
+	// Only include pipelines with at least one of these tags
+	// +optional
+	onlyTags []string,
+) (string, error) {
+	workflows := make(map[string]Workflow)
+	for _, w := range m.generateWorkflows(m.selectPipelines(onlyTags)) {
+		workflows[".github/workflows/"+w.pipeline.workflowFilename()] = w.workflow
+	}
+	contents, err := json.MarshalIndent(workflows, "", " ")
+	if err != nil {
+		return "", err
+	}
+	return string(contents), nil
 }
 
-func (m *Gha) otherWorkflows(ctx context.Context) *dagger.Directory {
+// Return the existing workflows which are not generated.
+// If keepGenerated is true, existing generated workflows are returned too.
+func (m *Gha) otherWorkflows(ctx context.Context, keepGenerated bool) *dagger.Directory {
 	dir := dag.Directory()
 	if repo := m.Settings.Repository; repo != nil {
 		if filenames, err := repo.Directory(".github/workflows").Entries(ctx); err == nil {
 			for _, filename := range filenames {
 				workflow := repo.File(".github/workflows/" + filename)
 				if contents, err := repo.File(".github/workflows/" + filename).Contents(ctx); err == nil {
-					if !strings.HasPrefix(contents, "# This file was generated.") {
+					if keepGenerated || !isGenerated(contents) {
 						dir = dir.WithFile(".github/workflows/"+filename, workflow)
 					}
 				}
@@ -130,10 +326,67 @@ func (m *Gha) otherWorkflows(ctx context.Context) *dagger.Directory {
 	return dir
 }
 
-func (m *Gha) generatedWorkflows() *dagger.Directory {
-	dir := dag.Directory()
+// Return the pipelines with at least one of the given tags.
+// If tags is nil, all pipelines are returned.
+func (m *Gha) selectPipelines(tags []string) []*Pipeline {
+	if tags == nil {
+		return m.Pipelines
+	}
+	var selected []*Pipeline
 	for _, p := range m.Pipelines {
-		dir = dir.WithDirectory(".", p.Config())
+		for _, tag := range tags {
+			if slices.Contains(p.Tags, tag) {
+				selected = append(selected, p)
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// Return the pipelines to emit as workflows, merging identical pipelines if enabled
+func (m *Gha) workflowPipelines(pipelines []*Pipeline) []*Pipeline {
+	if !m.Settings.MergeIdentical {
+		return pipelines
+	}
+	return mergeIdenticalPipelines(pipelines)
+}
+
+// A workflow generated from a pipeline, with the jobs of the pipelines it includes
+type generatedWorkflow struct {
+	pipeline *Pipeline
+	workflow Workflow
+}
+
+// Generate the workflows of the given pipelines concurrently
+func (m *Gha) generateWorkflows(pipelines []*Pipeline) []generatedWorkflow {
+	var (
+		workflows []generatedWorkflow
+		wg        sync.WaitGroup
+	)
+	for _, p := range m.workflowPipelines(pipelines) {
+		// Jobs of another pipeline's workflow are generated with it
+		if p.Workflow != "" {
+			continue
+		}
+		workflows = append(workflows, generatedWorkflow{pipeline: p})
+	}
+	for i := range workflows {
+		wg.Add(1)
+		go func(w *generatedWorkflow) {
+			defer wg.Done()
+			w.workflow = m.asWorkflow(w.pipeline)
+		}(&workflows[i])
+	}
+	wg.Wait()
+	return workflows
+}
+
+// Export generated workflows to a directory
+func (m *Gha) workflowsConfig(workflows []generatedWorkflow) *dagger.Directory {
+	dir := dag.Directory()
+	for _, w := range workflows {
+		dir = dir.WithDirectory(".", w.pipeline.workflowConfig(w.workflow))
 	}
 	return dir
 }
@@ -191,15 +444,50 @@ func (m *Gha) WithPipeline(
 	// The Dagger module to load
 	// +optional
 	module string,
-	// Dispatch jobs to the given runner
-	// Example: ["ubuntu-latest"]
+	// Dispatch jobs to a runner with all the given labels
+	// Example: ["self-hosted", "linux", "x64", "gpu"]
 	// +optional
 	runner []string,
+	// Number of days to keep the artifacts uploaded by the pipeline, between 1 and 400.
+	// Defaults to the module-wide setting
+	// +optional
+	artifactRetentionDays int,
+	// Pass secrets and configuration variables to the pipeline through an ephemeral env file,
+	// instead of one env variable each. This keeps the generated YAML small for pipelines with many of them.
+	// All the repository's secrets and variables are exposed to the exec step, which passes only
+	// the declared ones to the pipeline. Don't use it if other secrets must stay out of the step
+	// +optional
+	envFile bool,
+	// Dispatch jobs to a runner preset, instead of labels
+	// Possible values: "linux-amd64", "linux-arm64", "macos-arm64", "windows-amd64", "self-hosted-amd64", "self-hosted-arm64",
+	// "linux-4-cores", "linux-8-cores", "linux-16-cores", "linux-32-cores", "linux-64-cores"
+	// +optional
+	runnerPreset string,
+	// Tags to categorize the pipeline, for selective generation and validation.
+	// Not to be confused with git tags.
+	// Example: ["team:payments", "tier:slow"]
+	// +optional
+	tags []string,
+	// Generate the workflow with a trigger which never matches, to switch the pipeline off without deleting it
+	// +optional
+	paused bool,
+	// Team owning the pipeline. Its workflow file is prefixed with the team name, for example "payments-deploy.gen.yml"
+	// +optional
+	team string,
+	// Github users or teams to notify of changes to the pipeline's workflow, in a generated CODENOTIFY file
+	// Example: ["@my-org/payments"]
+	// +optional
+	owners []string,
 	// Github secrets to inject into the pipeline environment.
 	// For each secret, an env variable with the same name is created.
 	// Example: ["PROD_DEPLOY_TOKEN", "PRIVATE_SSH_KEY"]
 	// +optional
 	secrets []string,
+	// Env variables for the pipeline's job, in the form KEY=VALUE.
+	// They are added to the default env variables, and override them.
+	// Example: ["GOFLAGS=-mod=mod", "LOG_LEVEL=debug"]
+	// +optional
+	env []string,
 	// Use a sparse git checkout, only including the given paths
 	// Example: ["src", "tests", "Dockerfile"]
 	// +optional
@@ -220,12 +508,86 @@ func (m *Gha) WithPipeline(
 	// Dagger version to run this pipeline
 	// +optional
 	daggerVersion string,
-	// The maximum number of minutes to run the pipeline before killing the process
+	// Always install the dagger CLI and start a new engine, even if the runner image has the pinned version pre-installed or running
+	// +optional
+	forceInstall bool,
+	// The maximum number of minutes to run the pipeline before killing the process.
+	// Between 1 and 4320 (72 hours). Defaults to the module-wide timeout.
 	// +optional
 	timeoutMinutes int,
+	// Name of the workflow runs, displayed in the list of runs. Can include expressions.
+	// Example: "Deploy ${{ inputs.environment }} by @${{ github.actor }}"
+	// +optional
+	runName string,
+	// Only run the pipeline when this Github Actions expression is true, evaluated as the job's 'if:' condition.
+	// More conditions can be added with WithCondition.
+	// Example: "github.event.pull_request.author_association == 'MEMBER'"
+	// +optional
+	condition string,
+	// Don't fail the workflow when the pipeline fails. For example for experimental pipelines which shouldn't block merges
+	// +optional
+	continueOnError bool,
+	// Number of times to retry the dagger call when it fails, for flaky pipelines
+	// +optional
+	retries int,
+	// Export the dependency pins of the dagger module as an artifact, to debug module resolution differences
+	// +optional
+	exportModulePins bool,
+	// Fail before running the pipeline if the dependencies of the dagger module resolve differently
+	// from the pins committed in its dagger.json, including unpinned dependencies
+	// +optional
+	verifyModulePins bool,
+	// Upload this directory as an artifact when the pipeline fails. For example screenshots and videos of e2e tests
+	// Example: "./test-artifacts"
+	// +optional
+	failureArtifacts string,
+	// Deployment environment of the pipeline, to use its protection rules and secrets.
+	// Example: "production"
+	// +optional
+	environment string,
+	// URL of the deployment, displayed in the deployment history. It can use the output of the dagger call
+	// Example: "${{ steps.exec.outputs.stdout }}"
+	// +optional
+	environmentUrl string,
+	// Configuration variables to inject into the pipeline environment, from the repository, organization,
+	// or deployment environment. For each variable, an env variable with the same name is created.
+	// See https://docs.github.com/en/actions/writing-workflows/choosing-what-your-workflow-does/store-information-in-variables
+	// Example: ["API_URL", "REGION"]
+	// +optional
+	vars []string,
 	// Permissions to grant the pipeline
+	// Example: ["read_contents", "write_packages", "none_issues"]
 	// +optional
 	permissions Permissions,
+	// Deny all permissions which are not explicitly granted
+	// +optional
+	denyAllPermissions bool,
+	// Skip the pipeline on pull requests from forks, which don't have access to secrets
+	// +optional
+	skipForks bool,
+	// Shell used to run the dagger command, and the default shell of other 'run' steps.
+	// Generated steps always run in bash. {0} is replaced with the path of the command's script
+	// Example: "bash --noprofile --norc -eo pipefail {0}"
+	// +optional
+	shell string,
+	// Default working directory of 'run' steps, relative to the repository root. The dagger command runs in it
+	// Example: "services/payments"
+	// +optional
+	workingDirectory string,
+	// Log level of the Dagger Engine
+	// Possible values: "info", "debug", "trace"
+	// +optional
+	engineLogLevel string,
+	// Continuously capture the Dagger Engine logs to a file, uploaded as an artifact at the end of the job
+	// +optional
+	captureEngineLogs bool,
+	// Connect to a pre-existing engine, instead of starting one on the runner. Required on Windows runners.
+	// Example: "tcp://dagger-engine.internal:8080"
+	// +optional
+	engineHost string,
+	// Don't fail when Dagger Cloud is unreachable: run without traces instead
+	// +optional
+	cloudOptional bool,
 	// Run the pipeline on any issue comment activity
 	// +optional
 	onIssueComment bool,
@@ -235,6 +597,20 @@ func (m *Gha) WithPipeline(
 	onIssueCommentEdited bool,
 	// +optional
 	onIssueCommentDeleted bool,
+	// Only run on comments on pull requests, not on regular issues
+	// +optional
+	onIssueCommentPullRequestsOnly bool,
+	// Run the pipeline on comments starting with this slash command (ChatOps).
+	// The rest of the comment's first line is available as $SLASH_COMMAND_ARGS,
+	// and each argument as $SLASH_COMMAND_ARG1, $SLASH_COMMAND_ARG2, etc.
+	// Example: "/deploy"
+	// +optional
+	commandPrefix string,
+	// Run the pipeline when a label starting with this prefix is added to an issue or pull request (IssueOps).
+	// The rest of the label is available as $LABEL_ARG.
+	// Example: "backport-" runs the pipeline with LABEL_ARG=1.2 when the label "backport-1.2" is added
+	// +optional
+	labelPrefix string,
 	// Run the pipeline on any pull request activity
 	// +optional
 	onPullRequest bool,
@@ -247,10 +623,32 @@ func (m *Gha) WithPipeline(
 	// +optional
 	// +default="allow"
 	pullRequestConcurrency string,
+	// Configure this pipeline's concurrency for pushes to the same branch or tag.
+	//   - allow: all instances are allowed to run concurrently
+	//   - queue: new instances are queued, and run sequentially
+	//   - preempt: new instances run immediately, older ones are canceled
+	// Possible values: "allow", "preempt", "queue"
+	// +optional
+	// +default="allow"
+	pushConcurrency string,
+	// Custom concurrency group, overriding pullRequestConcurrency and pushConcurrency.
+	// Runs in the same group are queued, or cancelled with cancelInProgress.
+	// Example: "deploy-${{ inputs.environment }}"
+	// +optional
+	concurrencyGroup string,
+	// Cancel runs in progress in the custom concurrency group, when a new run starts
+	// +optional
+	cancelInProgress bool,
 	// +optional
 	onPullRequestBranches []string,
 	// +optional
 	onPullRequestPaths []string,
+	// Run the pipeline on pull requests, except those targeting the specified branches
+	// +optional
+	onPullRequestBranchesIgnore []string,
+	// Run the pipeline on pull requests, except those which only change the specified paths
+	// +optional
+	onPullRequestPathsIgnore []string,
 	// +optional
 	onPullRequestAssigned bool,
 	// +optional
@@ -293,6 +691,16 @@ func (m *Gha) WithPipeline(
 	onPullRequestAutoMergeEnabled bool,
 	// +optional
 	onPullRequestAutoMergeDisabled bool,
+	// Run the pipeline on pull requests from forks, with access to secrets,
+	// once a maintainer adds this label. The pull request's head is checked out explicitly.
+	// Only add the label after reviewing the code: it will run with access to your secrets!
+	// Example: "safe-to-test"
+	// +optional
+	safeToTestLabel string,
+	// Run the pipeline on pull requests, and on pushes to the default branch only.
+	// This avoids running the pipeline twice for each commit pushed to a pull request branch.
+	// +optional
+	onPullRequestAndPushToMain bool,
 	// Run the pipeline on any git push
 	// +optional
 	onPush bool,
@@ -302,18 +710,65 @@ func (m *Gha) WithPipeline(
 	// Run the pipeline on git push to the specified branches
 	// +optional
 	onPushBranches []string,
-	// Run the pipeline at a schedule time
+	// Run the pipeline on git push only if it changes the specified paths
+	// +optional
+	onPushPaths []string,
+	// Run the pipeline on git push, except to the specified branches
+	// +optional
+	onPushBranchesIgnore []string,
+	// Run the pipeline on git push, except to the specified tags
+	// +optional
+	onPushTagsIgnore []string,
+	// Run the pipeline on git push, except if it only changes the specified paths
+	// +optional
+	onPushPathsIgnore []string,
+	// Run the pipeline on git push, with filters encoded as JSON.
+	// All filters supported by Github are allowed: branches, branches-ignore, tags, tags-ignore, paths, paths-ignore
+	// Example: '{"branches": ["main"], "paths-ignore": ["docs/**"]}'
+	// +optional
+	onPushFilter string,
+	// Run the pipeline at a schedule time, with cron expressions or presets
+	// Example: ["0 */6 * * *", "nightly", "weekly(mon, 3)"]
 	// +optional
 	onSchedule []string,
-) *Gha {
+	// Run the pipeline after a Github Pages build
+	// +optional
+	onPageBuild bool,
+	// Emit the pipeline as a reusable workflow, which other workflows can call with 'uses:'
+	// See https://docs.github.com/en/actions/sharing-automations/reusing-workflows
+	// +optional
+	onWorkflowCall bool,
+	// Configure triggers from a preset. Other trigger flags are added on top of it.
+	// Possible values:
+	//   "ci": pull requests, and pushes to the default branch. Preempt older runs on the same pull request
+	//   "release": pushes of semver tags
+	//   "nightly": every night, and manual dispatch
+	// +optional
+	triggerPreset string,
+	// Triggers in the same YAML or JSON format as the 'on:' block of a Github workflow.
+	// Unlike the individual trigger flags, this allows combining types, branches and paths.
+	// Events without filters, and workflow_dispatch inputs, are supported.
+	// Example: '{"pull_request": {"types": ["opened", "synchronize"], "branches": ["main"], "paths": ["src/**"]}}'
+	// +optional
+	on string,
+) (*Gha, error) {
 	p := &Pipeline{
-		Name:           name,
-		Command:        command,
-		Module:         module,
-		Secrets:        secrets,
-		SparseCheckout: sparseCheckout,
-		LFS:            lfs,
-		Settings:       m.Settings,
+		Name:                    name,
+		Command:                 command,
+		Module:                  module,
+		Secrets:                 secrets,
+		SparseCheckout:          sparseCheckout,
+		LFS:                     lfs,
+		SkipForks:               skipForks,
+		Tags:                    tags,
+		Team:                    team,
+		Paused:                  paused,
+		Owners:                  owners,
+		PullRequestCommentsOnly: onIssueCommentPullRequestsOnly,
+		CommandPrefix:           commandPrefix,
+		LabelPrefix:             labelPrefix,
+		RunName:                 runName,
+		Settings:                m.Settings,
 	}
 	if !noDispatch {
 		p.Triggers.WorkflowDispatch = &WorkflowDispatchEvent{}
@@ -321,9 +776,52 @@ func (m *Gha) WithPipeline(
 	if pullRequestConcurrency != "" {
 		p.Settings.PullRequestConcurrency = pullRequestConcurrency
 	}
+	if pushConcurrency != "" {
+		p.Settings.PushConcurrency = pushConcurrency
+	}
+	// Presets apply after concurrency settings, so they can change the default
+	if triggerPreset != "" {
+		if err := p.applyTriggerPreset(triggerPreset); err != nil {
+			return m, fmt.Errorf("pipeline '%s': %w", name, err)
+		}
+	}
+	for _, setting := range []string{p.Settings.PullRequestConcurrency, p.Settings.PushConcurrency} {
+		switch setting {
+		case "", "allow", "queue", "preempt":
+		default:
+			return m, fmt.Errorf("pipeline '%s': unsupported concurrency setting '%s'. Possible values: allow, queue, preempt", name, setting)
+		}
+	}
+	if environmentUrl != "" && environment == "" {
+		return m, fmt.Errorf("pipeline '%s': environmentUrl requires an environment", name)
+	}
+	if condition != "" {
+		p.Conditions = append(p.Conditions, unwrapExpression(condition))
+	}
+	if continueOnError {
+		p.ContinueOnError = "true"
+	}
+	if retries < 0 || retries > maxRetries {
+		return m, fmt.Errorf("invalid number of retries: %d. It must be between 0 and %d", retries, maxRetries)
+	}
+	p.Retries = retries
+	p.FailureArtifacts = failureArtifacts
+	p.ExportModulePins = exportModulePins || verifyModulePins
+	p.VerifyModulePins = verifyModulePins
+	p.Environment = environment
+	p.Vars = vars
+	p.EnvironmentURL = environmentUrl
+	if cancelInProgress && concurrencyGroup == "" {
+		return m, fmt.Errorf("pipeline '%s': cancelInProgress requires a concurrencyGroup", name)
+	}
+	p.ConcurrencyGroup = concurrencyGroup
+	p.CancelInProgress = cancelInProgress
 	if permissions != nil {
 		p.Settings.Permissions = permissions
 	}
+	if denyAllPermissions {
+		p.Settings.DenyAllPermissions = denyAllPermissions
+	}
 	if debug {
 		p.Settings.Debug = debug
 	}
@@ -333,9 +831,51 @@ func (m *Gha) WithPipeline(
 	if runner != nil {
 		p.Settings.Runner = runner
 	}
+	if runnerPreset != "" {
+		labels, err := runnerPresetLabels(runnerPreset)
+		if err != nil {
+			return m, err
+		}
+		p.Settings.Runner = labels
+	}
+	if artifactRetentionDays != 0 {
+		p.Settings.ArtifactRetentionDays = artifactRetentionDays
+	}
+	if envFile {
+		p.Settings.EnvFile = envFile
+	}
 	if timeoutMinutes != 0 {
+		if timeoutMinutes < 0 || timeoutMinutes > maxTimeoutMinutes {
+			return m, fmt.Errorf("invalid timeout: %d minutes. It must be between 1 and %d", timeoutMinutes, maxTimeoutMinutes)
+		}
 		p.Settings.TimeoutMinutes = timeoutMinutes
 	}
+	if forceInstall {
+		p.Settings.ForceInstall = forceInstall
+	}
+	if shell != "" {
+		p.Settings.Shell = shell
+	}
+	p.WorkingDirectory = workingDirectory
+	if env != nil {
+		if _, err := parseEnv(env); err != nil {
+			return m, fmt.Errorf("pipeline '%s': %w", name, err)
+		}
+		// Don't modify the default env variables shared with other pipelines
+		p.Settings.Env = append(slices.Clone(p.Settings.Env), env...)
+	}
+	if engineLogLevel != "" {
+		p.Settings.EngineLogLevel = engineLogLevel
+	}
+	if captureEngineLogs {
+		p.Settings.CaptureEngineLogs = captureEngineLogs
+	}
+	if engineHost != "" {
+		p.Settings.EngineHost = engineHost
+	}
+	if cloudOptional {
+		p.Settings.CloudOptional = cloudOptional
+	}
 	if onIssueComment {
 		p.OnIssueComment(nil)
 	}
@@ -348,92 +888,197 @@ func (m *Gha) WithPipeline(
 	if onIssueCommentEdited {
 		p.OnIssueComment([]string{"edited"})
 	}
+	if commandPrefix != "" && p.Triggers.IssueComment == nil {
+		p.OnIssueComment([]string{"created"})
+	}
 	if onPullRequest {
-		p.OnPullRequest(nil, nil, nil)
+		p.OnPullRequest(nil, nil, nil, nil, nil)
 	}
 	if onPullRequestBranches != nil {
-		p.OnPullRequest(nil, onPullRequestBranches, nil)
+		p.OnPullRequest(nil, onPullRequestBranches, nil, nil, nil)
 	}
 	if onPullRequestPaths != nil {
-		p.OnPullRequest([]string{"paths"}, nil, onPullRequestPaths)
+		p.OnPullRequest(nil, nil, onPullRequestPaths, nil, nil)
+	}
+	if onPullRequestBranchesIgnore != nil {
+		p.OnPullRequest(nil, nil, nil, onPullRequestBranchesIgnore, nil)
+	}
+	if onPullRequestPathsIgnore != nil {
+		p.OnPullRequest(nil, nil, nil, nil, onPullRequestPathsIgnore)
 	}
 	if onPullRequestAssigned {
-		p.OnPullRequest([]string{"assigned"}, nil, nil)
+		p.OnPullRequest([]string{"assigned"}, nil, nil, nil, nil)
 	}
 	if onPullRequestUnassigned {
-		p.OnPullRequest([]string{"unassigned"}, nil, nil)
+		p.OnPullRequest([]string{"unassigned"}, nil, nil, nil, nil)
 	}
 	if onPullRequestLabeled {
-		p.OnPullRequest([]string{"labeled"}, nil, nil)
+		p.OnPullRequest([]string{"labeled"}, nil, nil, nil, nil)
 	}
 	if onPullRequestUnlabeled {
-		p.OnPullRequest([]string{"unlabeled"}, nil, nil)
+		p.OnPullRequest([]string{"unlabeled"}, nil, nil, nil, nil)
 	}
 	if onPullRequestOpened {
-		p.OnPullRequest([]string{"opened"}, nil, nil)
+		p.OnPullRequest([]string{"opened"}, nil, nil, nil, nil)
 	}
 	if onPullRequestEdited {
-		p.OnPullRequest([]string{"edited"}, nil, nil)
+		p.OnPullRequest([]string{"edited"}, nil, nil, nil, nil)
 	}
 	if onPullRequestClosed {
-		p.OnPullRequest([]string{"closed"}, nil, nil)
+		p.OnPullRequest([]string{"closed"}, nil, nil, nil, nil)
 	}
 	if onPullRequestReopened {
-		p.OnPullRequest([]string{"reopened"}, nil, nil)
+		p.OnPullRequest([]string{"reopened"}, nil, nil, nil, nil)
 	}
 	if onPullRequestSynchronize {
-		p.OnPullRequest([]string{"synchronize"}, nil, nil)
+		p.OnPullRequest([]string{"synchronize"}, nil, nil, nil, nil)
 	}
 	if onPullRequestConverted_to_draft {
-		p.OnPullRequest([]string{"converted_to_draft"}, nil, nil)
+		p.OnPullRequest([]string{"converted_to_draft"}, nil, nil, nil, nil)
 	}
 	if onPullRequestLocked {
-		p.OnPullRequest([]string{"locked"}, nil, nil)
+		p.OnPullRequest([]string{"locked"}, nil, nil, nil, nil)
 	}
 	if onPullRequestUnlocked {
-		p.OnPullRequest([]string{"unlocked"}, nil, nil)
+		p.OnPullRequest([]string{"unlocked"}, nil, nil, nil, nil)
 	}
 	if onPullRequestEnqueued {
-		p.OnPullRequest([]string{"enqueued"}, nil, nil)
+		p.OnPullRequest([]string{"enqueued"}, nil, nil, nil, nil)
 	}
 	if onPullRequestDequeued {
-		p.OnPullRequest([]string{"dequeued"}, nil, nil)
+		p.OnPullRequest([]string{"dequeued"}, nil, nil, nil, nil)
 	}
 	if onPullRequestMilestoned {
-		p.OnPullRequest([]string{"milestoned"}, nil, nil)
+		p.OnPullRequest([]string{"milestoned"}, nil, nil, nil, nil)
 	}
 	if onPullRequestDemilestoned {
-		p.OnPullRequest([]string{"demilestoned"}, nil, nil)
+		p.OnPullRequest([]string{"demilestoned"}, nil, nil, nil, nil)
 	}
 	if onPullRequestReadyForReview {
-		p.OnPullRequest([]string{"ready_for_review"}, nil, nil)
+		p.OnPullRequest([]string{"ready_for_review"}, nil, nil, nil, nil)
 	}
 	if onPullRequestReviewRequested {
-		p.OnPullRequest([]string{"review_requested"}, nil, nil)
+		p.OnPullRequest([]string{"review_requested"}, nil, nil, nil, nil)
 	}
 	if onPullRequestReviewRequestRemoved {
-		p.OnPullRequest([]string{"review_request_removed"}, nil, nil)
+		p.OnPullRequest([]string{"review_request_removed"}, nil, nil, nil, nil)
 	}
 	if onPullRequestAutoMergeEnabled {
-		p.OnPullRequest([]string{"auto_merge_enabled"}, nil, nil)
+		p.OnPullRequest([]string{"auto_merge_enabled"}, nil, nil, nil, nil)
 	}
 	if onPullRequestAutoMergeDisabled {
-		p.OnPullRequest([]string{"auto_merge_disabled"}, nil, nil)
+		p.OnPullRequest([]string{"auto_merge_disabled"}, nil, nil, nil, nil)
+	}
+	if safeToTestLabel != "" {
+		p.SafeToTestLabel = safeToTestLabel
+		p.Triggers.merge(WorkflowTriggers{
+			PullRequestTarget: &PullRequestEvent{Types: []string{"labeled"}},
+		})
+	}
+	if onPullRequestAndPushToMain {
+		p.onPullRequestAndPushToMain()
 	}
 	if onPush {
-		p.OnPush(nil, nil)
+		p.OnPush(nil, nil, nil, nil, nil, nil)
 	}
 	if onPushBranches != nil {
-		p.OnPush(onPushBranches, nil)
+		p.OnPush(onPushBranches, nil, nil, nil, nil, nil)
 	}
 	if onPushTags != nil {
-		p.OnPush(nil, onPushTags)
+		p.OnPush(nil, onPushTags, nil, nil, nil, nil)
+	}
+	if onPushPaths != nil {
+		p.OnPush(nil, nil, onPushPaths, nil, nil, nil)
+	}
+	if onPushBranchesIgnore != nil {
+		p.OnPush(nil, nil, nil, onPushBranchesIgnore, nil, nil)
+	}
+	if onPushTagsIgnore != nil {
+		p.OnPush(nil, nil, nil, nil, onPushTagsIgnore, nil)
+	}
+	if onPushPathsIgnore != nil {
+		p.OnPush(nil, nil, nil, nil, nil, onPushPathsIgnore)
+	}
+	if onPushFilter != "" {
+		filter, err := parsePushFilter(onPushFilter)
+		if err != nil {
+			return m, err
+		}
+		p.OnPush(filter.Branches, filter.Tags, filter.Paths, filter.BranchesIgnore, filter.TagsIgnore, filter.PathsIgnore)
 	}
 	if onSchedule != nil {
 		p.OnSchedule(onSchedule)
 	}
+	if onPageBuild {
+		p.OnPageBuild()
+	}
+	if onWorkflowCall {
+		p.OnWorkflowCall()
+	}
+	if on != "" {
+		triggers, inputs, err := parseTriggers(on)
+		if err != nil {
+			return m, fmt.Errorf("pipeline '%s': %w", name, err)
+		}
+		p.Triggers.merge(triggers)
+		if err := p.addDispatchInputs(inputs); err != nil {
+			return m, fmt.Errorf("pipeline '%s': %w", name, err)
+		}
+	}
+	// After other triggers, so their activity types are extended, not replaced
+	if labelPrefix != "" {
+		p.onLabeled()
+	}
+	if err := p.checkTriggers(); err != nil {
+		return m, fmt.Errorf("pipeline '%s': %w", name, err)
+	}
+	if err := p.checkSchedule(); err != nil {
+		return m, fmt.Errorf("pipeline '%s': %w", name, err)
+	}
+	return m.addPipeline(p)
+}
+
+// Also run when a label is added to an issue or pull request.
+// Existing issue and pull request triggers keep their activity types.
+func (p *Pipeline) onLabeled() {
+	// No issue types means all types, including labeled
+	if p.Triggers.Issues == nil || len(p.Triggers.Issues.Types) > 0 {
+		p.OnIssues([]string{"labeled"})
+	}
+	// No pull request types means the default types, which don't include labeled
+	if pr := p.Triggers.PullRequest; pr != nil && len(pr.Types) == 0 {
+		pr.Types = slices.Clone(defaultPullRequestTypes)
+	}
+	p.OnPullRequest([]string{"labeled"}, nil, nil, nil, nil)
+}
+
+// Add a pipeline, or merge it into an existing pipeline with the same name and command
+func (m *Gha) addPipeline(p *Pipeline) (*Gha, error) {
+	// Pipelines with the same name and command are merged into a single workflow
+	if existing := m.pipeline(p.Name); existing != nil && existing.Command == p.Command && existing.Module == p.Module {
+		if existing.settingsKey() != p.settingsKey() {
+			return m, fmt.Errorf("pipeline '%s' is already registered with different settings. Only triggers and tags can differ", p.Name)
+		}
+		triggers, err := existing.Triggers.union(p.Triggers)
+		if err != nil {
+			return m, fmt.Errorf("pipeline '%s': %w. Please register it under another name", p.Name, err)
+		}
+		existing.Triggers = triggers
+		existing.Reusable = existing.Reusable || p.Reusable
+		existing.RawTriggers = append(existing.RawTriggers, p.RawTriggers...)
+		existing.Tags = appendUnique(existing.Tags, p.Tags...)
+		return m, nil
+	}
+	// Make sure we don't overwrite another pipeline's workflow file
+	for _, other := range m.Pipelines {
+		if other.workflowFilename() == p.workflowFilename() {
+			return m, fmt.Errorf(
+				"pipeline '%s' conflicts with pipeline '%s': both generate the workflow file '%s'. Please rename one of them",
+				p.Name, other.Name, p.workflowFilename())
+		}
+	}
 	m.Pipelines = append(m.Pipelines, p)
-	return m
+	return m, nil
 }
 
 func (p *Pipeline) OnIssueComment(
@@ -445,7 +1090,21 @@ func (p *Pipeline) OnIssueComment(
 	if p.Triggers.IssueComment == nil {
 		p.Triggers.IssueComment = &IssueCommentEvent{}
 	}
-	p.Triggers.IssueComment.Types = append(p.Triggers.IssueComment.Types, types...)
+	p.Triggers.IssueComment.Types = appendUnique(p.Triggers.IssueComment.Types, types...)
+	return p
+}
+
+// Add a trigger to execute a Dagger pipeline on issue activity
+func (p *Pipeline) OnIssues(
+	// Run only for certain types of issue events
+	// See https://docs.github.com/en/actions/writing-workflows/choosing-when-your-workflow-runs/events-that-trigger-workflows#issues
+	// +optional
+	types []string,
+) *Pipeline {
+	if p.Triggers.Issues == nil {
+		p.Triggers.Issues = &IssuesEvent{}
+	}
+	p.Triggers.Issues.Types = appendUnique(p.Triggers.Issues.Types, types...)
 	return p
 }
 
@@ -461,13 +1120,21 @@ func (p *Pipeline) OnPullRequest(
 	// Run only for pull requests that target specific paths
 	// +optional
 	paths []string,
+	// Don't run for pull requests that target specific branches
+	// +optional
+	branchesIgnore []string,
+	// Don't run for pull requests that only change specific paths
+	// +optional
+	pathsIgnore []string,
 ) *Pipeline {
 	if p.Triggers.PullRequest == nil {
 		p.Triggers.PullRequest = &PullRequestEvent{}
 	}
-	p.Triggers.PullRequest.Types = append(p.Triggers.PullRequest.Types, types...)
-	p.Triggers.PullRequest.Branches = append(p.Triggers.PullRequest.Branches, branches...)
-	p.Triggers.PullRequest.Paths = append(p.Triggers.PullRequest.Paths, paths...)
+	p.Triggers.PullRequest.Types = appendUnique(p.Triggers.PullRequest.Types, types...)
+	p.Triggers.PullRequest.Branches = appendUnique(p.Triggers.PullRequest.Branches, branches...)
+	p.Triggers.PullRequest.Paths = appendUnique(p.Triggers.PullRequest.Paths, paths...)
+	p.Triggers.PullRequest.BranchesIgnore = appendUnique(p.Triggers.PullRequest.BranchesIgnore, branchesIgnore...)
+	p.Triggers.PullRequest.PathsIgnore = appendUnique(p.Triggers.PullRequest.PathsIgnore, pathsIgnore...)
 	return p
 }
 
@@ -479,30 +1146,196 @@ func (p *Pipeline) OnPush(
 	// Run only on push to specific tags
 	// +optional
 	tags []string,
+	// Run only on push which changes specific paths
+	// +optional
+	paths []string,
+	// Don't run on push to specific branches
+	// +optional
+	branchesIgnore []string,
+	// Don't run on push to specific tags
+	// +optional
+	tagsIgnore []string,
+	// Don't run on push which only changes specific paths
+	// +optional
+	pathsIgnore []string,
 ) *Pipeline {
 	if p.Triggers.Push == nil {
 		p.Triggers.Push = &PushEvent{}
 	}
-	p.Triggers.Push.Branches = append(p.Triggers.Push.Branches, branches...)
-	p.Triggers.Push.Tags = append(p.Triggers.Push.Tags, tags...)
+	p.Triggers.Push.Branches = appendUnique(p.Triggers.Push.Branches, branches...)
+	p.Triggers.Push.Tags = appendUnique(p.Triggers.Push.Tags, tags...)
+	p.Triggers.Push.Paths = appendUnique(p.Triggers.Push.Paths, paths...)
+	p.Triggers.Push.BranchesIgnore = appendUnique(p.Triggers.Push.BranchesIgnore, branchesIgnore...)
+	p.Triggers.Push.TagsIgnore = appendUnique(p.Triggers.Push.TagsIgnore, tagsIgnore...)
+	p.Triggers.Push.PathsIgnore = appendUnique(p.Triggers.Push.PathsIgnore, pathsIgnore...)
 	return p
 }
 
 // Add a trigger to execute a Dagger pipeline on a schedule time
 func (p *Pipeline) OnSchedule(
 	// Cron exressions from https://pubs.opengroup.org/onlinepubs/9699919799/utilities/crontab.html#tag_20_25_07.
+	// Presets are also supported: "hourly", "daily(hour)", "nightly(hour)", "weekly(day, hour)", "monthly(day, hour)"
 	// +optional
 	expressions []string,
 ) *Pipeline {
-	if p.Triggers.Schedule == nil {
-		p.Triggers.Schedule = make([]ScheduledEvent, len(expressions))
-		for i, expression := range expressions {
-			p.Triggers.Schedule[i] = ScheduledEvent{Cron: expression}
+	for _, expression := range expressions {
+		event := ScheduledEvent{Cron: expandSchedulePreset(expression)}
+		if !slices.Contains(p.Triggers.Schedule, event) {
+			p.Triggers.Schedule = append(p.Triggers.Schedule, event)
 		}
 	}
 	return p
 }
 
+// Add a trigger to execute a Dagger pipeline after a Github Pages build
+func (p *Pipeline) OnPageBuild() *Pipeline {
+	if p.Triggers.PageBuild == nil {
+		p.Triggers.PageBuild = &PageBuildEvent{}
+	}
+	return p
+}
+
+// Parse push trigger filters encoded as JSON
+func parsePushFilter(filter string) (*PushEvent, error) {
+	decoder := json.NewDecoder(strings.NewReader(filter))
+	decoder.DisallowUnknownFields()
+	var event PushEvent
+	if err := decoder.Decode(&event); err != nil {
+		return nil, fmt.Errorf("invalid push filter: %w", err)
+	}
+	return &event, nil
+}
+
+// Parse triggers in the format of a workflow's 'on:' block.
+// JSON is valid YAML, so both are supported.
+// Inputs of workflow_dispatch are returned separately, since they are stored in the pipeline.
+func parseTriggers(spec string) (WorkflowTriggers, []PipelineInput, error) {
+	var (
+		triggers WorkflowTriggers
+		inputs   []PipelineInput
+		doc      yaml.Node
+	)
+	if err := yaml.Unmarshal([]byte(spec), &doc); err != nil {
+		return triggers, nil, fmt.Errorf("invalid triggers: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return triggers, nil, nil
+	}
+	events, err := triggerEvents(doc.Content[0])
+	if err != nil {
+		return triggers, nil, err
+	}
+	if dispatch := events["workflow_dispatch"]; dispatch != nil {
+		if inputs, err = parseDispatchInputs(dispatch); err != nil {
+			return triggers, nil, err
+		}
+	}
+	if err := decodeStrict(events, &triggers); err != nil {
+		return triggers, nil, fmt.Errorf("invalid triggers: %w", err)
+	}
+	return triggers, inputs, nil
+}
+
+// Return the events of an 'on:' block, which can be a single event, a list of events, or a map.
+// Events without filters have an empty value, rather than null.
+func triggerEvents(node *yaml.Node) (map[string]*yaml.Node, error) {
+	events := map[string]*yaml.Node{}
+	switch node.Kind {
+	case yaml.ScalarNode:
+		events[node.Value] = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	case yaml.SequenceNode:
+		for _, event := range node.Content {
+			if event.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("invalid triggers: a list of events must only contain event names")
+			}
+			events[event.Value] = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			event, value := node.Content[i].Value, node.Content[i+1]
+			// An event without filters, for example "push:"
+			if value.Kind == yaml.ScalarNode && value.Tag == "!!null" {
+				value = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			}
+			events[event] = value
+		}
+	default:
+		return nil, fmt.Errorf("invalid triggers: expected an event, a list of events or a map of events")
+	}
+	return events, nil
+}
+
+// Parse and remove the inputs of a workflow_dispatch event
+func parseDispatchInputs(dispatch *yaml.Node) ([]PipelineInput, error) {
+	var (
+		inputs  []PipelineInput
+		content []*yaml.Node
+	)
+	for i := 0; i+1 < len(dispatch.Content); i += 2 {
+		if dispatch.Content[i].Value != "inputs" {
+			content = append(content, dispatch.Content[i], dispatch.Content[i+1])
+			continue
+		}
+		var decoded map[string]DispatchInput
+		if err := decodeStrict(dispatch.Content[i+1], &decoded); err != nil {
+			return nil, fmt.Errorf("invalid workflow_dispatch inputs: %w", err)
+		}
+		for _, name := range sortedKeys(decoded) {
+			input := PipelineInput{
+				Name:        name,
+				Description: decoded[name].Description,
+				Type:        decoded[name].Type,
+				Required:    decoded[name].Required,
+				Options:     decoded[name].Options,
+			}
+			if input.Type == "" {
+				input.Type = "string"
+			}
+			if value := decoded[name].Default; value != nil {
+				input.Default = fmt.Sprint(value)
+			}
+			if err := input.checkDispatch(); err != nil {
+				return nil, err
+			}
+			inputs = append(inputs, input)
+		}
+	}
+	dispatch.Content = content
+	return inputs, nil
+}
+
+// Decode a value into a struct, rejecting unknown fields
+func decodeStrict(value any, out any) error {
+	contents, err := yaml.Marshal(value)
+	if err != nil {
+		return err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+	return decoder.Decode(out)
+}
+
+// Add inputs to a manually dispatched pipeline, rejecting duplicates
+func (p *Pipeline) addDispatchInputs(inputs []PipelineInput) error {
+	for _, input := range inputs {
+		if slices.ContainsFunc(p.DispatchInputs, func(i PipelineInput) bool { return i.Name == input.Name }) {
+			return fmt.Errorf("duplicate workflow_dispatch input '%s'", input.Name)
+		}
+		p.DispatchInputs = append(p.DispatchInputs, input)
+	}
+	return nil
+}
+
+// Append values to a list, skipping values already in the list
+func appendUnique(list []string, values ...string) []string {
+	for _, value := range values {
+		if !slices.Contains(list, value) {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
 // Lookup a pipeline
 func (m *Gha) pipeline(name string) *Pipeline {
 	for _, p := range m.Pipelines {
@@ -528,30 +1361,156 @@ type Pipeline struct {
 	// +private
 	LFS bool
 	// +private
+	SkipForks bool
+	// +private
+	Tags []string
+	// +private
+	Team string
+	// +private
+	Owners []string
+	// +private
+	PullRequestCommentsOnly bool
+	// +private
+	CommandPrefix string
+	// +private
+	LabelPrefix string
+	// +private
+	SafeToTestLabel string
+	// +private
+	Conditions []string
+	// +private
+	After []string
+	// +private
+	Environment string
+	// +private
+	EnvironmentURL string
+	// +private
+	Vars []string
+	// +private
+	ConcurrencyGroup string
+	// +private
+	CancelInProgress bool
+	// +private
 	Settings Settings
 	// +private
 	Triggers WorkflowTriggers
-}
-
-func (p *Pipeline) Config() *dagger.Directory {
-	return p.asWorkflow().Config(p.workflowFilename(), p.Settings.AsJson)
-}
-
-func (p *Pipeline) concurrency() *WorkflowConcurrency {
-	setting := p.Settings.PullRequestConcurrency
-	if setting == "" || setting == "allow" {
-		return nil
-	}
-	if (setting != "queue") && (setting != "preempt") {
-		panic("Unsupported value for 'pullRequestConcurrency': " + setting)
-	}
-	concurrency := &WorkflowConcurrency{
-		// If in a pull request: concurrency group is unique to workflow + head branch
-		// If NOT in a pull request: concurrency group is unique to run ID -> no grouping
-		Group: "${{ github.workflow }}-${{ github.head_ref || github.run_id }}",
+	// +private
+	Reusable bool
+	// +private
+	CallInputs []PipelineInput
+	// +private
+	DispatchInputs []PipelineInput
+	// +private
+	RawTriggers []string
+	// +private
+	RawSteps []RawStep
+	// +private
+	RepositoryDispatches []RepositoryDispatch
+	// +private
+	ReportArtifact string
+	// +private
+	ContinueOnError string
+	// +private
+	FailureArtifacts string
+	// +private
+	StepConditions []StepCondition
+	// +private
+	StepTimeouts []StepTimeout
+	// +private
+	Workflow string
+	// +private
+	Needs []string
+	// +private
+	Outputs []PipelineOutput
+	// +private
+	Matrix []MatrixDimension
+	// +private
+	MatrixExclusions []MatrixExclusion
+	// +private
+	Container *JobContainerSettings
+	// +private
+	ExportModulePins bool
+	// +private
+	VerifyModulePins bool
+	// +private
+	Services []JobServiceSettings
+	// +private
+	WorkingDirectory string
+	// +private
+	Paused bool
+	// +private
+	TrustedPublishing string
+	// +private
+	LogScans []LogScan
+	// +private
+	RunName string
+	// +private
+	Retries int
+	// +private
+	WorkflowCalls []WorkflowCall
+	// +private
+	CallSecrets []CallSecret
+	// +private
+	EphemeralRunner *EphemeralRunner
+	// +private
+	Calls []DaggerCall
+	// +private
+	DynamicMatrix *DynamicMatrix
+	// +private
+	NoCheckout bool
+	// +private
+	NeedsOutputs []NeedsOutput
+}
+
+func (p *Pipeline) Config() *dagger.Directory {
+	return p.workflowConfig(p.asWorkflow())
+}
+
+func (p *Pipeline) workflowConfig(workflow Workflow) *dagger.Directory {
+	dir := workflow.Config(p.workflowFilename(), p.Settings.AsJson, p.Settings.Banner)
+	if mirror := p.Settings.JsonMirror; mirror != "" {
+		dir = dir.WithDirectory(".", workflow.JsonConfig(path.Join(mirror, p.workflowName()+".json")))
 	}
-	if setting == "preempt" {
+	return dir
+}
+
+func (p *Pipeline) concurrency() *WorkflowConcurrency {
+	if p.ConcurrencyGroup != "" {
+		concurrency := &WorkflowConcurrency{Group: p.ConcurrencyGroup}
+		if p.CancelInProgress {
+			concurrency.CancelInProgress = true
+		}
+		return concurrency
+	}
+	pr, push := p.Settings.PullRequestConcurrency, p.Settings.PushConcurrency
+	for _, setting := range []string{pr, push} {
+		if setting != "" && setting != "allow" && setting != "queue" && setting != "preempt" {
+			panic("Unsupported concurrency setting: " + setting)
+		}
+	}
+	// Runs which are not grouped get a unique concurrency group: their run ID
+	var keys []string
+	if pr == "queue" || pr == "preempt" {
+		// If in a pull request: concurrency group is unique to workflow + head branch
+		keys = append(keys, "github.head_ref")
+	}
+	if push == "queue" || push == "preempt" {
+		// If on push: concurrency group is unique to workflow + pushed ref
+		keys = append(keys, "(github.event_name == 'push' && github.ref)")
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	concurrency := &WorkflowConcurrency{
+		Group: fmt.Sprintf("${{ github.workflow }}-${{ %s || github.run_id }}", strings.Join(keys, " || ")),
+	}
+	switch {
+	case pr == "preempt" && push == "preempt":
 		concurrency.CancelInProgress = true
+	case pr == "preempt":
+		concurrency.CancelInProgress = "${{ github.event_name != 'push' }}"
+	case push == "preempt":
+		concurrency.CancelInProgress = "${{ github.event_name == 'push' }}"
 	}
 	return concurrency
 }
@@ -563,23 +1522,170 @@ func (p *Pipeline) checkSecretNames() error {
 		if !validName.MatchString(secretName) {
 			return errors.New("invalid secret name: '" + secretName + "' must contain only alphanumeric characters and underscores")
 		}
+		if githubSecret := p.githubSecret(secretName); !validName.MatchString(githubSecret) {
+			return errors.New("invalid secret name: '" + githubSecret + "' must contain only alphanumeric characters and underscores")
+		}
+	}
+	for _, name := range p.Vars {
+		if !validName.MatchString(name) {
+			return errors.New("invalid variable name: '" + name + "' must contain only alphanumeric characters and underscores")
+		}
 	}
 	return nil
 }
 
-func (p *Pipeline) checkCommandAndModule(ctx context.Context, repo *dagger.Directory) error {
-	script := "dagger call"
-	if p.Module != "" {
-		script = script + " -m '" + p.Module + "' "
+// Check that trigger filters are not combined in ways Github rejects
+func (p *Pipeline) checkTriggers() error {
+	if push := p.Triggers.Push; push != nil {
+		if len(push.Branches) > 0 && len(push.BranchesIgnore) > 0 {
+			return errors.New("push trigger: can't filter on both branches and branches to ignore")
+		}
+		if len(push.Tags) > 0 && len(push.TagsIgnore) > 0 {
+			return errors.New("push trigger: can't filter on both tags and tags to ignore")
+		}
+		if len(push.Paths) > 0 && len(push.PathsIgnore) > 0 {
+			return errors.New("push trigger: can't filter on both paths and paths to ignore")
+		}
+	}
+	if err := checkPullRequestEvent("pull_request", p.Triggers.PullRequest); err != nil {
+		return err
+	}
+	if err := checkPullRequestEvent("pull_request_target", p.Triggers.PullRequestTarget); err != nil {
+		return err
+	}
+	if ic := p.Triggers.IssueComment; ic != nil {
+		if err := checkActivityTypes("issue_comment", ic.Types); err != nil {
+			return err
+		}
+	}
+	if issues := p.Triggers.Issues; issues != nil {
+		if err := checkActivityTypes("issues", issues.Types); err != nil {
+			return err
+		}
+	}
+	if run := p.Triggers.WorkflowRun; run != nil {
+		if len(run.Workflows) == 0 {
+			return errors.New("workflow_run trigger: workflows are required")
+		}
+		if err := checkActivityTypes("workflow_run", run.Types); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkPullRequestEvent(event string, pr *PullRequestEvent) error {
+	if pr == nil {
+		return nil
+	}
+	if len(pr.Branches) > 0 && len(pr.BranchesIgnore) > 0 {
+		return fmt.Errorf("%s trigger: can't filter on both branches and branches to ignore", event)
 	}
-	script = script + p.Command + " --help"
-	_, err := dag.
+	if len(pr.Paths) > 0 && len(pr.PathsIgnore) > 0 {
+		return fmt.Errorf("%s trigger: can't filter on both paths and paths to ignore", event)
+	}
+	return checkActivityTypes(event, pr.Types)
+}
+
+// Activity types allowed by Github for each event
+// See https://docs.github.com/en/actions/writing-workflows/choosing-when-your-workflow-runs/events-that-trigger-workflows
+var activityTypes = map[string][]string{
+	"pull_request":        pullRequestActivityTypes,
+	"pull_request_target": pullRequestActivityTypes,
+	"issue_comment":       {"created", "edited", "deleted"},
+	"workflow_run":        {"completed", "requested", "in_progress"},
+	"issues": {
+		"opened", "edited", "deleted", "transferred", "pinned", "unpinned", "closed", "reopened",
+		"assigned", "unassigned", "labeled", "unlabeled", "locked", "unlocked", "milestoned", "demilestoned",
+	},
+}
+
+var pullRequestActivityTypes = []string{
+	"assigned", "unassigned", "labeled", "unlabeled", "opened", "edited", "closed", "reopened",
+	"synchronize", "converted_to_draft", "locked", "unlocked", "enqueued", "dequeued",
+	"milestoned", "demilestoned", "ready_for_review", "review_requested", "review_request_removed",
+	"auto_merge_enabled", "auto_merge_disabled",
+}
+
+// Check that activity types are allowed for the given event
+func checkActivityTypes(event string, types []string) error {
+	allowed := activityTypes[event]
+	for _, t := range types {
+		if slices.Contains(allowed, t) {
+			continue
+		}
+		if fixed := strings.ReplaceAll(strings.ToLower(t), "-", "_"); slices.Contains(allowed, fixed) {
+			return fmt.Errorf("%s trigger: invalid activity type '%s'. Did you mean '%s'?", event, t, fixed)
+		}
+		return fmt.Errorf("%s trigger: invalid activity type '%s'. Allowed types: %s", event, t, strings.Join(allowed, ", "))
+	}
+	return nil
+}
+
+// Warn about pipelines which need secrets, but may run on pull requests from forks
+func (p *Pipeline) checkForkSecrets() {
+	if len(p.Secrets) > 0 && p.Triggers.PullRequest != nil && !p.SkipForks {
+		fmt.Fprintf(os.Stderr,
+			"warning: pipeline '%s' uses secrets and runs on pull requests, but secrets are not available to pull requests from forks. Consider setting skipForks\n",
+			p.Name)
+	}
+}
+
+// Return a container to check pipelines against a repository.
+// Validate builds it once for all pipelines. Its definition doesn't change
+// between runs, so the engine cache also reuses it across runs.
+func checkContainer(repo *dagger.Directory) *dagger.Container {
+	return dag.
 		Wolfi().
 		Container(dagger.WolfiContainerOpts{
 			Packages: []string{"dagger", "bash"},
 		}).
 		WithMountedDirectory("/src", repo).
-		WithWorkdir("/src").
+		WithWorkdir("/src")
+}
+
+// Warn about declared secrets which the command never references,
+// and env variables which the command references but are never set
+func (p *Pipeline) checkSecretsUsage() {
+	references := p.envLookups()
+	// Dagger secret arguments can reference env variables with 'env:NAME'
+	for _, match := range regexp.MustCompile(`env:([a-zA-Z_][a-zA-Z0-9_]*)`).FindAllStringSubmatch(strings.Join(p.commands(), " "), -1) {
+		references = appendUnique(references, match[1])
+	}
+	for _, secret := range p.Secrets {
+		if !slices.Contains(references, secret) {
+			fmt.Fprintf(os.Stderr, "warning: pipeline '%s': secret %s is never referenced by the command\n", p.Name, secret)
+		}
+	}
+	env := p.execEnv()
+	jobEnv := p.jobEnv()
+	for _, name := range references {
+		if _, ok := env[name]; ok {
+			continue
+		}
+		if _, ok := jobEnv[name]; ok {
+			continue
+		}
+		if slices.Contains(p.Secrets, name) || slices.Contains(p.Vars, name) {
+			continue
+		}
+		if strings.HasPrefix(name, "SLASH_COMMAND_") {
+			continue
+		}
+		if name == "LABEL_ARG" && p.LabelPrefix != "" {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "warning: pipeline '%s': command references $%s, which is not a declared secret or known variable\n", p.Name, name)
+	}
+}
+
+func (p *Pipeline) checkCommandAndModule(ctx context.Context, ctr *dagger.Container) error {
+	script := "dagger call"
+	if p.Module != "" {
+		script = script + " -m '" + p.Module + "' "
+	}
+	script = script + p.commands()[0] + " --help"
+	_, err := ctr.
 		WithExec(
 			[]string{"bash", "-c", script},
 			dagger.ContainerWithExecOpts{ExperimentalPrivilegedNesting: true},
@@ -594,12 +1700,127 @@ func (p *Pipeline) Check(
 	// +defaultPath="/"
 	repo *dagger.Directory,
 ) error {
+	return p.check(ctx, checkContainer(repo))
+}
+
+func (p *Pipeline) check(ctx context.Context, ctr *dagger.Container) error {
 	if err := p.checkSecretNames(); err != nil {
 		return err
 	}
-	if err := p.checkCommandAndModule(ctx, repo); err != nil {
+	if err := p.checkTriggers(); err != nil {
+		return err
+	}
+	if err := p.checkSchedule(); err != nil {
+		return err
+	}
+	if _, err := parseEnv(p.Settings.Env); err != nil {
+		return err
+	}
+	if err := p.Settings.Permissions.check(); err != nil {
+		return err
+	}
+	if err := p.checkTimeout(); err != nil {
+		return err
+	}
+	if err := p.checkEngineHost(); err != nil {
+		return err
+	}
+	if err := p.checkRunnerOS(); err != nil {
+		return err
+	}
+	if err := p.checkEphemeralRunner(); err != nil {
+		return err
+	}
+	if err := p.checkActCompatible(); err != nil {
+		return err
+	}
+	if err := p.checkArtifactRetention(); err != nil {
+		return err
+	}
+	if err := p.checkMatrixReferences(); err != nil {
+		return err
+	}
+	if err := p.checkMatrixExclusions(); err != nil {
+		return err
+	}
+	switch p.Settings.EngineLogLevel {
+	case "", "info", "debug", "trace":
+	default:
+		return fmt.Errorf("unsupported engine log level: '%s'", p.Settings.EngineLogLevel)
+	}
+	if err := p.checkCommandTemplates(); err != nil {
+		return err
+	}
+	if err := p.checkCommandAndModule(ctx, ctr); err != nil {
+		return err
+	}
+	if err := p.checkCalls(ctx, ctr); err != nil {
 		return err
 	}
+	p.checkEnvSize()
+	p.checkForkSecrets()
+	p.checkSecretsUsage()
+	p.checkDuplicateRuns()
+	p.checkProtectedTags()
+	return nil
+}
+
+// Check that act can run the pipeline. Features with a compatible alternative
+// are substituted when generating the workflow, the others are rejected.
+func (p *Pipeline) checkActCompatible() error {
+	if !p.Settings.ActCompatible {
+		return nil
+	}
+	var unsupported []string
+	if perms := p.Settings.Permissions.JobPermissions(); perms != nil && (perms.IdToken == PermissionWrite || perms.IdToken == PermissionRead) {
+		unsupported = append(unsupported, "OIDC tokens (id-token permission, trusted publishing)")
+	}
+	if p.EphemeralRunner != nil {
+		unsupported = append(unsupported, "ephemeral runners")
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("act can't emulate: %s", strings.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+func (p *Pipeline) checkEngineHost() error {
+	host := p.Settings.EngineHost
+	if host == "" {
+		// Windows runners are checked by checkRunnerOS
+		return nil
+	}
+	if !strings.HasPrefix(host, "tcp://") && !strings.HasPrefix(host, "${{") {
+		return fmt.Errorf("unsupported engine host: '%s'. It must be a tcp:// address", host)
+	}
+	if p.devEngine() {
+		return fmt.Errorf("a dev engine can't be used with a remote engine host")
+	}
+	if p.Settings.EngineLogLevel != "" || p.Settings.CaptureEngineLogs {
+		return fmt.Errorf("the logs of a remote engine can't be configured or captured")
+	}
+	return nil
+}
+
+// Maximum number of retries of a dagger call
+const maxRetries = 10
+
+// Maximum job timeout supported by Github, in minutes
+const maxTimeoutMinutes = 72 * 60
+
+// Maximum run time of a job on Github-hosted runners, in minutes
+const hostedTimeoutMinutes = 6 * 60
+
+func (p *Pipeline) checkTimeout() error {
+	timeout := p.Settings.TimeoutMinutes
+	if timeout < 0 || timeout > maxTimeoutMinutes {
+		return fmt.Errorf("invalid timeout: %d minutes. It must be between 1 and %d", timeout, maxTimeoutMinutes)
+	}
+	if timeout > hostedTimeoutMinutes && !slices.Contains(p.Settings.Runner, "self-hosted") {
+		fmt.Fprintf(os.Stderr,
+			"warning: pipeline '%s' has a timeout of %d minutes, but Github-hosted runners stop jobs after %d minutes\n",
+			p.Name, timeout, hostedTimeoutMinutes)
+	}
 	return nil
 }
 
@@ -607,39 +1828,237 @@ func (p *Pipeline) Check(
 // The workflow will have no triggers, they should be filled separately.
 func (p *Pipeline) asWorkflow() Workflow {
 	var steps []JobStep
-	// FIXME: make checkout configurable
-	steps = append(steps, p.checkoutStep())
-	steps = append(steps, p.installDaggerSteps()...)
-	steps = append(steps, p.warmEngineStep(), p.callDaggerStep())
-	if p.Settings.StopEngine {
+	steps = append(steps, p.rawSteps("start")...)
+	if p.NoCheckout {
+		// The module is remote: only the CLI is needed
+		steps = append(steps, p.installDaggerSteps()...)
+		if p.Settings.EngineHost != "" {
+			steps = append(steps, p.connectEngineStep())
+		}
+	} else if p.Settings.EngineHost != "" {
+		// The engine is already running elsewhere
+		steps = append(steps, p.installDaggerSteps()...)
+		steps = append(steps, p.connectEngineStep())
+		steps = append(steps, p.checkoutStep())
+		steps = append(steps, p.rawSteps("after-checkout")...)
+	} else if p.runnerOS() != "linux" {
+		// The engine is provisioned by the first call: there is no engine to warm up
+		steps = append(steps, p.installDaggerSteps()...)
+		steps = append(steps, p.checkoutStep())
+		steps = append(steps, p.rawSteps("after-checkout")...)
+	} else if p.devEngine() {
+		// The dev engine is built from the checked out source
+		// FIXME: make checkout configurable
+		steps = append(steps, p.checkoutStep())
+		steps = append(steps, p.rawSteps("after-checkout")...)
+		steps = append(steps, p.installDaggerSteps()...)
+		steps = append(steps, p.warmEngineStep(false))
+	} else {
+		// Provision the engine in the background, while checking out
+		steps = append(steps, p.installDaggerSteps()...)
+		if p.Settings.EngineLogLevel != "" {
+			steps = append(steps, p.startEngineStep())
+		}
+		steps = append(steps, p.warmEngineStep(true))
+		steps = append(steps, p.checkoutStep())
+		steps = append(steps, p.rawSteps("after-checkout")...)
+		steps = append(steps, p.waitEngineStep())
+	}
+	if p.Settings.CaptureEngineLogs {
+		steps = append(steps, p.captureEngineLogsStep())
+	}
+	steps = append(steps, p.startServicesSteps()...)
+	steps = append(steps, p.rawSteps("before-exec")...)
+	if p.ExportModulePins {
+		steps = append(steps, p.exportModulePinsStep())
+	}
+	steps = append(steps, p.callDaggerSteps()...)
+	if len(p.LogScans) > 0 {
+		steps = append(steps, p.scanLogsStep())
+	}
+	if p.ReportArtifact != "" {
+		steps = append(steps, p.uploadReportStep())
+	}
+	if p.FailureArtifacts != "" {
+		steps = append(steps, p.uploadFailureArtifactsStep())
+	}
+	if p.ExportModulePins {
+		steps = append(steps, p.uploadModulePinsStep())
+	}
+	for _, dispatch := range p.RepositoryDispatches {
+		steps = append(steps, p.repositoryDispatchStep(dispatch))
+	}
+	steps = append(steps, p.rawSteps("after-exec")...)
+	steps = append(steps, p.stopServicesSteps()...)
+	if p.Settings.StopEngine && p.Settings.EngineHost == "" && p.runnerOS() == "linux" {
 		steps = append(steps, p.stopEngineStep())
 	}
-	return Workflow{
+	if p.Settings.CaptureEngineLogs {
+		steps = append(steps, p.uploadEngineLogsStep())
+	}
+	steps = append(steps, p.rawSteps("end")...)
+	steps = p.applyStepConditions(steps)
+	steps = p.applyStepTimeouts(steps)
+	steps = p.applyArtifactRetention(steps)
+	steps = p.applyRunnerMatrix(steps)
+	steps = p.beforeCheckoutWorkingDirectory(steps)
+	return p.withDynamicMatrix(p.withEphemeralRunner(Workflow{
 		Name:        p.Name,
-		On:          p.Triggers,
+		RunName:     p.RunName,
+		On:          p.workflowOn(),
 		Concurrency: p.concurrency(),
+		Permissions: p.workflowPermissions(),
 		Jobs: map[string]Job{
 			p.jobID(): Job{
 				// The job name is used by the "required checks feature" in branch protection rules
-				Name:           p.Name,
-				RunsOn:         p.Settings.Runner,
-				Permissions:    p.JobPermissions(),
-				Steps:          steps,
-				TimeoutMinutes: p.Settings.TimeoutMinutes,
-				Outputs: map[string]string{
-					"stdout": "${{ steps.exec.outputs.stdout }}",
-					"stderr": "${{ steps.exec.outputs.stderr }}",
-				},
+				Name:            p.Name,
+				RunsOn:          p.Settings.Runner,
+				Permissions:     p.JobPermissions(),
+				If:              p.jobCondition(),
+				Environment:     p.jobEnvironment(),
+				Strategy:        p.jobStrategy(),
+				Container:       p.jobContainer(),
+				Services:        p.jobServices(),
+				Defaults:        p.jobDefaults(),
+				Steps:           steps,
+				TimeoutMinutes:  p.Settings.TimeoutMinutes,
+				Env:             p.jobEnv(),
+				Outputs:         p.jobOutputs(),
+				ContinueOnError: p.jobContinueOnError(),
 			},
 		},
+	}))
+}
+
+// Return the condition for running the job, if any
+func (p *Pipeline) jobCondition() string {
+	var conditions []string
+	if p.SkipForks {
+		conditions = append(conditions, "github.event_name != 'pull_request' || github.event.pull_request.head.repo.full_name == github.repository")
+	}
+	if p.PullRequestCommentsOnly {
+		conditions = append(conditions, "github.event_name != 'issue_comment' || github.event.issue.pull_request")
+	}
+	if p.CommandPrefix != "" {
+		conditions = append(conditions, fmt.Sprintf(
+			"github.event_name != 'issue_comment' || startsWith(github.event.comment.body, %s)",
+			quoteExpressionString(p.CommandPrefix)))
+	}
+	if p.SafeToTestLabel != "" {
+		conditions = append(conditions, fmt.Sprintf(
+			"github.event_name != 'pull_request_target' || github.event.label.name == %s",
+			quoteExpressionString(p.SafeToTestLabel)))
+	}
+	if p.LabelPrefix != "" {
+		conditions = append(conditions, fmt.Sprintf(
+			"(github.event_name != 'issues' && github.event_name != 'pull_request') || github.event.action != 'labeled' || startsWith(github.event.label.name, %s)",
+			quoteExpressionString(p.LabelPrefix)))
+	}
+	if len(p.After) > 0 {
+		conditions = append(conditions, "github.event_name != 'workflow_run' || github.event.workflow_run.conclusion == 'success'")
+	}
+	conditions = append(conditions, p.Conditions...)
+	return andConditions(conditions...)
+}
+
+// Only run a pipeline when a condition is met.
+// Multiple conditions are combined with a logical AND.
+func (m *Gha) WithCondition(
+	// Name of the pipeline
+	pipeline string,
+	// Github Actions expression, evaluated as the job's 'if:' condition
+	// See https://docs.github.com/en/actions/writing-workflows/choosing-what-your-workflow-does/evaluate-expressions-in-workflows-and-actions
+	// Example: "contains(github.event.pull_request.labels.*.name, 'run-e2e')"
+	condition string,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	p.Conditions = append(p.Conditions, unwrapExpression(condition))
+	return m, nil
+}
+
+// Remove the optional ${{ }} wrapper around an expression, so it can be combined with others
+func unwrapExpression(expr string) string {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "${{") && strings.HasSuffix(expr, "}}") {
+		return strings.TrimSpace(expr[3 : len(expr)-2])
+	}
+	return expr
+}
+
+// Quote a string literal for a Github expression
+func quoteExpressionString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// Combine Github expressions with a logical AND
+func andConditions(conditions ...string) string {
+	switch len(conditions) {
+	case 0:
+		return ""
+	case 1:
+		return conditions[0]
+	}
+	parts := make([]string, len(conditions))
+	for i, condition := range conditions {
+		parts[i] = "(" + condition + ")"
+	}
+	return strings.Join(parts, " && ")
+}
+
+// Return the complete set of triggers to serialize in the workflow file
+func (p *Pipeline) workflowOn() WorkflowOn {
+	if p.Paused {
+		return pausedTriggers()
+	}
+	on := WorkflowOn{
+		Push:              p.Triggers.Push,
+		PullRequest:       p.Triggers.PullRequest,
+		PullRequestTarget: p.Triggers.PullRequestTarget,
+		Schedule:          p.Triggers.Schedule,
+		IssueComment:      p.Triggers.IssueComment,
+		Issues:            p.Triggers.Issues,
+		WorkflowRun:       p.Triggers.WorkflowRun,
+		PageBuild:         p.Triggers.PageBuild,
+	}
+	if p.Triggers.WorkflowDispatch != nil {
+		on.WorkflowDispatch = p.workflowDispatchTrigger()
+	}
+	if p.Reusable {
+		on.WorkflowCall = p.workflowCallEvent()
+	}
+	on.Raw = p.rawTriggers()
+	return on
+}
+
+func (p *Pipeline) jobEnvironment() *JobEnvironment {
+	if p.Environment == "" {
+		return nil
+	}
+	return &JobEnvironment{
+		Name: p.Environment,
+		URL:  p.EnvironmentURL,
 	}
 }
 
 func (p *Pipeline) JobPermissions() *JobPermissions {
-	return p.Settings.Permissions.JobPermissions()
+	perms := p.Settings.Permissions.JobPermissions()
+	if perms == nil && p.Settings.DenyAllPermissions {
+		// An empty block denies all permissions
+		perms = &JobPermissions{}
+	}
+	return perms
 }
 
 func (p *Pipeline) workflowFilename() string {
+	// Add the file extension
+	return p.workflowName() + p.Settings.FileExtension
+}
+
+// Return the workflow filename, without extension
+func (p *Pipeline) workflowName() string {
 	var name string
 	// Convert to lowercase
 	name = strings.ToLower(p.Name)
@@ -647,18 +2066,20 @@ func (p *Pipeline) workflowFilename() string {
 	re := regexp.MustCompile(`[^a-z0-9]+`)
 	name = re.ReplaceAllString(name, "-")
 	// Trim leading and trailing hyphens
-	name = strings.Trim(name, "-")
-	// Add the file extension
-	return name + p.Settings.FileExtension
+	return p.teamPrefix() + strings.Trim(name, "-")
 }
 
 func (p *Pipeline) jobID() string {
+	if p.Workflow != "" {
+		return p.jobIDFor(p.Workflow)
+	}
 	return "dagger"
 }
 
 func (p *Pipeline) checkoutStep() JobStep {
 	step := JobStep{
 		Name: "Checkout",
+		ID:   "checkout",
 		Uses: "actions/checkout@v4",
 		With: map[string]string{},
 	}
@@ -673,18 +2094,125 @@ func (p *Pipeline) checkoutStep() JobStep {
 	if p.LFS {
 		step.With["lfs"] = "true"
 	}
+	var refs []string
+	if p.SafeToTestLabel != "" {
+		// pull_request_target checks out the base branch by default: explicitly check out the head.
+		// Don't persist the token with write access, since the code is untrusted.
+		refs = append(refs, "github.event_name == 'pull_request_target' && github.event.pull_request.head.sha")
+		step.With["persist-credentials"] = "false"
+	}
+	if len(p.After) > 0 {
+		// workflow_run checks out the default branch by default: check out the commit of the upstream pipeline.
+		refs = append(refs, "github.event_name == 'workflow_run' && github.event.workflow_run.head_sha")
+	}
+	if len(refs) > 0 {
+		step.With["ref"] = fmt.Sprintf("${{ %s || '' }}", strings.Join(refs, " || "))
+	}
 	return step
 }
 
-func (p *Pipeline) warmEngineStep() JobStep {
+func (p *Pipeline) warmEngineStep(background bool) JobStep {
+	if background {
+		return p.bashStep("warm-engine", map[string]string{"WARM_ENGINE_IN_BACKGROUND": "1"})
+	}
 	return p.bashStep("warm-engine", nil)
 }
 
+// Wait for the engine warm-up started in the background
+func (p *Pipeline) waitEngineStep() JobStep {
+	return p.bashStep("wait-engine", nil)
+}
+
+// Connect to a pre-existing engine, and check that it is reachable
+func (p *Pipeline) connectEngineStep() JobStep {
+	return p.bashStep("connect-engine", map[string]string{
+		"ENGINE_HOST": p.Settings.EngineHost,
+	})
+}
+
+// Start the engine explicitly, to configure it
+func (p *Pipeline) startEngineStep() JobStep {
+	return p.bashStep("start-engine", map[string]string{
+		"ENGINE_LOG_LEVEL": p.Settings.EngineLogLevel,
+	})
+}
+
+// Capture the engine logs to a file in the background, for the rest of the job
+func (p *Pipeline) captureEngineLogsStep() JobStep {
+	return p.bashStep("capture-engine-logs", nil)
+}
+
+// Return a unique artifact name for this job.
+// Artifact names must be unique in a workflow run, across jobs and matrix legs.
+func (p *Pipeline) artifactName(name string) string {
+	return fmt.Sprintf("%s-%s-${{ strategy.job-index }}", name, p.jobID())
+}
+
+func (p *Pipeline) uploadEngineLogsStep() JobStep {
+	return JobStep{
+		Name: "Upload engine logs",
+		If:   "always()",
+		Uses: "actions/upload-artifact@v4",
+		With: map[string]string{
+			"name":              p.artifactName("dagger-engine-logs"),
+			"path":              "${{ runner.temp }}/dagger-engine.log",
+			"if-no-files-found": "ignore",
+		},
+	}
+}
+
+// Export the dependency pins of the dagger module, as resolved before running the pipeline
+func (p *Pipeline) exportModulePinsStep() JobStep {
+	env := map[string]string{}
+	if p.Module != "" {
+		env["DAGGER_MODULE"] = p.Module
+	}
+	if p.VerifyModulePins {
+		env["VERIFY_MODULE_PINS"] = "1"
+	}
+	return p.bashStep("export-module-pins", env)
+}
+
+func (p *Pipeline) uploadModulePinsStep() JobStep {
+	return JobStep{
+		Name: "Upload module pins",
+		If:   "always()",
+		Uses: "actions/upload-artifact@v4",
+		With: map[string]string{
+			"name":              p.artifactName("dagger-module-pins"),
+			"path":              "${{ runner.temp }}/dagger-module-pins.json",
+			"if-no-files-found": "ignore",
+		},
+	}
+}
+
+// Upload the failure artifacts directory, only if the pipeline failed
+func (p *Pipeline) uploadFailureArtifactsStep() JobStep {
+	return JobStep{
+		Name: "Upload failure artifacts",
+		If:   "failure()",
+		Uses: "actions/upload-artifact@v4",
+		With: map[string]string{
+			"name":              p.artifactName("failure-artifacts"),
+			"path":              p.FailureArtifacts,
+			"if-no-files-found": "ignore",
+		},
+	}
+}
+
+// Check if the pipeline runs a dev engine built from source, rather than a released version
+func (p *Pipeline) devEngine() bool {
+	v := p.Settings.DaggerVersion
+	return (v != "latest") && !semver.IsValid(v)
+}
+
 func (p *Pipeline) installDaggerSteps() []JobStep {
-	if v := p.Settings.DaggerVersion; (v == "latest") || (semver.IsValid(v)) {
-		return []JobStep{
-			p.bashStep("install-dagger", map[string]string{"DAGGER_VERSION": v}),
+	if v := p.Settings.DaggerVersion; !p.devEngine() {
+		env := map[string]string{"DAGGER_VERSION": v}
+		if p.Settings.ForceInstall {
+			env["DAGGER_FORCE_INSTALL"] = "1"
 		}
+		return []JobStep{p.bashStep("install-dagger", env)}
 	}
 	// Interpret dagger version as a local source, and build it (dev engine)
 	return []JobStep{
@@ -712,7 +2240,7 @@ func (p *Pipeline) installDaggerSteps() []JobStep {
 // Analyze the pipeline command, and return a list of env variables it references
 func (p *Pipeline) envLookups() []string {
 	var lookups = make(map[string]interface{})
-	_, err := shell.Expand(p.Command, func(name string) string {
+	_, err := shell.Expand(strings.Join(p.commands(), " "), func(name string) string {
 		lookups[name] = nil
 		return name
 	})
@@ -732,22 +2260,136 @@ func (p *Pipeline) envLookups() []string {
 	return result
 }
 
-func (p *Pipeline) callDaggerStep() JobStep {
+// Return the steps which call dagger. Env values too large to be passed
+// in one variable are split, and joined back by the exec script.
+func (p *Pipeline) callDaggerSteps() []JobStep {
+	return []JobStep{p.bashStep("exec", splitLargeEnv(p.execEnv()))}
+}
+
+// Warn about env values which risk exceeding Github's length limits
+func (p *Pipeline) checkEnvSize() {
+	env := p.execEnv()
+	for _, name := range sortedKeys(env) {
+		n := len(env[name])
+		if n <= maxEnvValueLength {
+			continue
+		}
+		parts := splitEnvValue(env[name], maxEnvValueLength)
+		if slices.ContainsFunc(parts, func(part string) bool { return len(part) > maxEnvValueLength }) {
+			fmt.Fprintf(os.Stderr,
+				"warning: pipeline '%s': env variable %s contains an expression longer than %d characters, which can't be split\n",
+				p.Name, name, maxEnvValueLength)
+			continue
+		}
+		fmt.Fprintf(os.Stderr,
+			"warning: pipeline '%s': env variable %s is %d characters long (limit %d). It will be split into %d variables\n",
+			p.Name, name, n, maxEnvValueLength, len(parts))
+	}
+	if n := envSize(env); n > maxEnvSize {
+		fmt.Fprintf(os.Stderr,
+			"warning: pipeline '%s': exec step env is %d characters long, beyond the limit of %d\n",
+			p.Name, n, maxEnvSize)
+	}
+}
+
+// Return the env variables to inject in the exec step
+func (p *Pipeline) execEnv() map[string]string {
 	env := map[string]string{}
 	// Debug mode
 	if p.Settings.Debug {
 		env["DEBUG"] = "1"
 	}
 	// Inject dagger command
-	env["COMMAND"] = "dagger call -q " + p.Command
+	env["COMMAND"] = p.daggerCommand()
+	if p.Settings.Shell != "" {
+		env["COMMAND_SHELL"] = p.Settings.Shell
+	}
+	p.secretsEnv(env)
+	// Prepare the trusted publishing credentials
+	p.trustedPublishingEnv(env)
+	// Inject the outputs of upstream jobs
+	for _, output := range p.NeedsOutputs {
+		env[output.Env] = fmt.Sprintf("${{ needs.%s.outputs.%s }}", output.JobID, output.Output)
+	}
+	// Inject inputs
+	if p.Triggers.WorkflowDispatch != nil {
+		for _, input := range p.DispatchInputs {
+			env[input.envName()] = input.expression()
+		}
+	}
+	if p.Reusable {
+		for _, input := range p.CallInputs {
+			env[input.envName()] = input.expression()
+		}
+	}
+	// Inject the triggering comment, to parse slash command arguments
+	if p.CommandPrefix != "" {
+		env["SLASH_COMMAND_PREFIX"] = p.CommandPrefix
+		env["SLASH_COMMAND_BODY"] = "${{ github.event.comment.body }}"
+	}
+	// Inject the triggering label, to extract its argument
+	if p.LabelPrefix != "" {
+		env["LABEL_PREFIX"] = p.LabelPrefix
+		env["LABEL_NAME"] = "${{ github.event.label.name }}"
+	}
+	// Extract named outputs from the output of the command
+	if len(p.Outputs) > 0 {
+		env["DAGGER_OUTPUTS"] = p.outputsSpec()
+	}
+	// Retry the command when it fails
+	if p.Retries > 0 {
+		env["DAGGER_RETRIES"] = strconv.Itoa(p.Retries)
+	}
+	// Save the output and logs of the command, to scan them
+	if len(p.LogScans) > 0 {
+		env["LOG_FILE"] = p.logFile()
+	}
+	// Save the output of the command as a report
+	if p.ReportArtifact != "" {
+		env["REPORT_FILE"] = p.reportFile()
+	}
+	p.daggerEnv(env)
+	for _, key := range p.envLookups() {
+		if strings.HasPrefix(key, "GITHUB_") {
+			// Inject Github context keys
+			// github.ref becomes $GITHUB_REF, etc.
+			env[key] = fmt.Sprintf("${{ github.%s }}", strings.ToLower(key))
+		} else if strings.HasPrefix(key, "RUNNER_") {
+			// Inject Runner context keys
+			// runner.ref becomes $RUNNER_REF, etc.
+			env[key] = fmt.Sprintf("${{ runner.%s }}", strings.ToLower(key))
+		}
+	}
+	return env
+}
+
+// Add the pipeline's secrets and configuration variables to an env
+func (p *Pipeline) secretsEnv(env map[string]string) {
+	if p.Settings.EnvFile {
+		// Inject secrets and configuration variables in bulk, through an ephemeral env file
+		p.envFileEnv(env)
+		return
+	}
 	// Inject user-defined secrets
 	for _, secretName := range p.Secrets {
-		env[secretName] = fmt.Sprintf("${{ secrets.%s }}", secretName)
+		env[secretName] = fmt.Sprintf("${{ secrets.%s }}", p.githubSecret(secretName))
 	}
+	// Inject configuration variables
+	for _, name := range p.Vars {
+		env[name] = fmt.Sprintf("${{ vars.%s }}", name)
+	}
+}
+
+// Add the module and Dagger Cloud settings of the pipeline to an env
+func (p *Pipeline) daggerEnv(env map[string]string) {
 	// Inject module name
 	if p.Module != "" {
 		env["DAGGER_MODULE"] = p.Module
 	}
+	// Run without traces if Dagger Cloud is unreachable
+	if p.Settings.CloudOptional && !p.Settings.NoTraces {
+		env["DAGGER_CLOUD_OPTIONAL"] = "1"
+	}
 	// Inject Dagger Cloud token
 	if !p.Settings.NoTraces {
 		if p.Settings.PublicToken != "" {
@@ -755,33 +2397,41 @@ func (p *Pipeline) callDaggerStep() JobStep {
 			// For backwards compatibility with older engines
 			env["_EXPERIMENTAL_DAGGER_CLOUD_TOKEN"] = p.Settings.PublicToken
 		} else {
-			env["DAGGER_CLOUD_TOKEN"] = "${{ secrets.DAGGER_CLOUD_TOKEN }}"
+			env["DAGGER_CLOUD_TOKEN"] = fmt.Sprintf("${{ secrets.%s }}", p.githubSecret("DAGGER_CLOUD_TOKEN"))
 			// For backwards compatibility with older engines
-			env["_EXPERIMENTAL_DAGGER_CLOUD_TOKEN"] = "${{ secrets.DAGGER_CLOUD_TOKEN }}"
-		}
-	}
-	for _, key := range p.envLookups() {
-		if strings.HasPrefix(key, "GITHUB_") {
-			// Inject Github context keys
-			// github.ref becomes $GITHUB_REF, etc.
-			env[key] = fmt.Sprintf("${{ github.%s }}", strings.ToLower(key))
-		} else if strings.HasPrefix(key, "RUNNER_") {
-			// Inject Runner context keys
-			// runner.ref becomes $RUNNER_REF, etc.
-			env[key] = fmt.Sprintf("${{ runner.%s }}", strings.ToLower(key))
+			env["_EXPERIMENTAL_DAGGER_CLOUD_TOKEN"] = fmt.Sprintf("${{ secrets.%s }}", p.githubSecret("DAGGER_CLOUD_TOKEN"))
 		}
 	}
-	return p.bashStep("exec", env)
 }
 
 func (p *Pipeline) stopEngineStep() JobStep {
-	return p.bashStep("scripts/stop-engine.sh", nil)
+	step := p.bashStep("stop-engine", nil)
+	// Stop the engine even if the pipeline failed, to flush its cache and logs
+	step.If = "always()"
+	return step
 }
 
 // Return a github actions step which executes the script embedded at scripts/<filename>.sh
 // The script must be checked in with the module source code.
 func (p *Pipeline) bashStep(id string, env map[string]string) JobStep {
 	filename := "scripts/" + id + ".sh"
+	return JobStep{
+		Name:  filename,
+		ID:    id,
+		Shell: "bash",
+		Run:   readScript(filename),
+		Env:   env,
+	}
+}
+
+// Scripts embedded in the module source, by filename.
+// They are read once, since each pipeline embeds the same scripts.
+var scripts sync.Map
+
+func readScript(filename string) string {
+	if script, ok := scripts.Load(filename); ok {
+		return script.(string)
+	}
 	script, err := dag.
 		CurrentModule().
 		Source().
@@ -792,11 +2442,34 @@ func (p *Pipeline) bashStep(id string, env map[string]string) JobStep {
 		// (don't want to plumb error checking everywhere)
 		panic(err)
 	}
-	return JobStep{
-		Name:  filename,
-		ID:    id,
-		Shell: "bash",
-		Run:   script,
-		Env:   env,
+	scripts.Store(filename, script)
+	return script
+}
+
+// Return the default settings of the job's 'run' steps
+func (p *Pipeline) jobDefaults() *Defaults {
+	if p.Settings.Shell == "" && p.WorkingDirectory == "" {
+		return nil
+	}
+	return &Defaults{Run: &RunDefaults{
+		Shell:            p.Settings.Shell,
+		WorkingDirectory: p.WorkingDirectory,
+	}}
+}
+
+// The default working directory doesn't exist before the checkout:
+// run the steps before it in the runner's temporary directory
+func (p *Pipeline) beforeCheckoutWorkingDirectory(steps []JobStep) []JobStep {
+	if p.WorkingDirectory == "" {
+		return steps
+	}
+	for i, step := range steps {
+		if step.ID == "checkout" {
+			break
+		}
+		if step.Run != "" && step.WorkingDirectory == "" {
+			steps[i].WorkingDirectory = "${{ runner.temp }}"
+		}
 	}
+	return steps
 }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Add a housekeeping pipeline, which runs a remote module on a schedule, like pruning caches
+// or collecting metrics. It generates the smallest possible workflow: the repository is not
+// checked out, it can't be dispatched manually, and the job has no permissions.
+func (m *Gha) WithMaintenancePipeline(
+	// Pipeline name
+	name string,
+	// The Dagger command to execute
+	// Example: "prune --older-than=7d"
+	command string,
+	// The remote Dagger module to load. The repository is not checked out, so it can't be a local module
+	// Example: "github.com/my-org/ops/cache@v1"
+	module string,
+	// When to run the pipeline, with cron expressions or presets
+	// Example: ["nightly"]
+	schedule []string,
+	// Github secrets to inject into the pipeline environment
+	// +optional
+	secrets []string,
+) (*Gha, error) {
+	if module == "" || strings.HasPrefix(module, ".") || strings.HasPrefix(module, "/") {
+		return m, fmt.Errorf("pipeline '%s': the module must be remote, since the repository is not checked out", name)
+	}
+	p := &Pipeline{
+		Name:       name,
+		Command:    command,
+		Module:     module,
+		Secrets:    secrets,
+		Settings:   m.Settings,
+		NoCheckout: true,
+	}
+	p.Settings.Permissions = Permissions{}
+	p.OnSchedule(schedule)
+	if err := p.checkSchedule(); err != nil {
+		return m, fmt.Errorf("pipeline '%s': %w", name, err)
+	}
+	if len(p.Triggers.Schedule) == 0 {
+		return m, fmt.Errorf("pipeline '%s': a schedule is required", name)
+	}
+	return m.addPipeline(p)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// A dimension of a pipeline's strategy matrix
+type MatrixDimension struct {
+	Key    string
+	Values []string
+}
+
+// Run a pipeline for each value of a matrix dimension. Dimensions are combined.
+// The command can reference the current values, for example "test --go-version=${{ matrix.go }}"
+func (m *Gha) WithMatrix(
+	// Name of the pipeline
+	pipeline string,
+	// Key of the dimension
+	// Example: "go"
+	key string,
+	// Values of the dimension
+	// Example: ["1.22", "1.23"]
+	values []string,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	if p.DynamicMatrix != nil {
+		return m, fmt.Errorf("pipeline '%s' already has a dynamic matrix", pipeline)
+	}
+	if !regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_-]*$`).MatchString(key) {
+		return m, fmt.Errorf("invalid matrix key: '%s'", key)
+	}
+	if len(values) == 0 {
+		return m, fmt.Errorf("matrix key '%s': at least one value is required", key)
+	}
+	if i := slices.IndexFunc(p.Matrix, func(d MatrixDimension) bool { return d.Key == key }); i >= 0 {
+		p.Matrix[i].Values = appendUnique(p.Matrix[i].Values, values...)
+		return m, nil
+	}
+	p.Matrix = append(p.Matrix, MatrixDimension{Key: key, Values: values})
+	return m, nil
+}
+
+// Return the strategy of the pipeline's job, if it has a matrix
+func (p *Pipeline) jobStrategy() *Strategy {
+	if len(p.Matrix) == 0 {
+		return nil
+	}
+	matrix := make(map[string]any, len(p.Matrix)+1)
+	for _, dimension := range p.Matrix {
+		matrix[dimension.Key] = dimension.Values
+	}
+	if exclude := p.matrixExclude(); exclude != nil {
+		matrix["exclude"] = exclude
+	}
+	return &Strategy{Matrix: matrix}
+}
+
+// Check that the matrix values referenced by the command exist
+func (p *Pipeline) checkMatrixReferences() error {
+	re := regexp.MustCompile(`\$\{\{\s*matrix\.([a-zA-Z0-9_-]+)\s*\}\}`)
+	if p.DynamicMatrix != nil {
+		// The keys of the matrix are only known when the workflow runs
+		return nil
+	}
+	for _, match := range re.FindAllStringSubmatch(strings.Join(p.commands(), " "), -1) {
+		key := match[1]
+		if !slices.ContainsFunc(p.Matrix, func(d MatrixDimension) bool { return d.Key == key }) {
+			return fmt.Errorf("command references matrix key '%s', which is not defined. See WithMatrix", key)
+		}
+	}
+	return nil
+}
+
+// A combination of matrix values to skip, optionally only when a condition is true
+type MatrixExclusion struct {
+	Combination []string
+	Condition   string
+}
+
+// Skip a combination of matrix values, optionally only when a condition is true.
+// For example, skip costly cross-arch builds on pull requests, but run them on main and tags.
+// All conditional exclusions of a pipeline must share the same condition.
+func (m *Gha) WithMatrixExclude(
+	// Name of the pipeline
+	pipeline string,
+	// Matrix values to skip, in the form KEY=VALUE
+	// Example: ["arch=arm64", "os=windows-latest"]
+	combination []string,
+	// Only skip the combination when this Github Actions expression is true
+	// Example: "github.event_name == 'pull_request'"
+	// +optional
+	condition string,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	if len(combination) == 0 {
+		return m, fmt.Errorf("matrix exclusion: at least one KEY=VALUE is required")
+	}
+	if _, err := parseEnv(combination); err != nil {
+		return m, fmt.Errorf("matrix exclusion: %w", err)
+	}
+	condition = unwrapExpression(condition)
+	for _, other := range p.MatrixExclusions {
+		if condition != "" && other.Condition != "" && other.Condition != condition {
+			return m, fmt.Errorf("matrix exclusion: conditions differ: '%s' and '%s'", other.Condition, condition)
+		}
+	}
+	p.MatrixExclusions = append(p.MatrixExclusions, MatrixExclusion{
+		Combination: combination,
+		Condition:   condition,
+	})
+	return m, nil
+}
+
+// Return the matrix exclusions: a list of combinations, or an expression evaluating to one
+func (p *Pipeline) matrixExclude() any {
+	var (
+		// Exclusions are serialized as JSON lists, even if empty
+		always      = []map[string]string{}
+		conditional []map[string]string
+		condition   string
+	)
+	for _, exclusion := range p.MatrixExclusions {
+		combination, _ := parseEnv(exclusion.Combination)
+		if exclusion.Condition == "" {
+			always = append(always, combination)
+		} else {
+			conditional = append(conditional, combination)
+			condition = exclusion.Condition
+		}
+	}
+	if len(conditional) == 0 {
+		if len(always) == 0 {
+			return nil
+		}
+		return always
+	}
+	whenTrue, err := json.Marshal(append(conditional, always...))
+	if err != nil {
+		panic(err)
+	}
+	whenFalse, err := json.Marshal(always)
+	if err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("${{ (%s) && fromJSON(%s) || fromJSON(%s) }}",
+		condition, quoteExpressionString(string(whenTrue)), quoteExpressionString(string(whenFalse)))
+}
+
+// Check that matrix exclusions only reference defined keys
+func (p *Pipeline) checkMatrixExclusions() error {
+	for _, exclusion := range p.MatrixExclusions {
+		combination, err := parseEnv(exclusion.Combination)
+		if err != nil {
+			return err
+		}
+		for key := range combination {
+			if !slices.ContainsFunc(p.Matrix, func(d MatrixDimension) bool { return d.Key == key }) {
+				return fmt.Errorf("matrix exclusion references key '%s', which is not defined. See WithMatrix", key)
+			}
+		}
+	}
+	return nil
+}
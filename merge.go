@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Merge pipelines which generate the same job, differing only by their triggers.
+// Each group of identical pipelines is replaced by a copy of its first pipeline,
+// with the combined triggers of the group.
+// Pipelines whose triggers can't be combined keep their own workflow.
+func mergeIdenticalPipelines(pipelines []*Pipeline) []*Pipeline {
+	var (
+		merged []*Pipeline
+		byKey  = map[string]*Pipeline{}
+	)
+	for _, p := range pipelines {
+		key := p.jobKey()
+		if first, ok := byKey[key]; ok {
+			triggers, err := first.Triggers.union(p.Triggers)
+			if err == nil {
+				first.Triggers = triggers
+				first.Reusable = first.Reusable || p.Reusable
+				first.RawTriggers = append(first.RawTriggers, p.RawTriggers...)
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "warning: pipeline '%s' not merged with pipeline '%s': %s\n", p.Name, first.Name, err)
+			merged = append(merged, p)
+			continue
+		}
+		copied := *p
+		copied.RawTriggers = append([]string(nil), p.RawTriggers...)
+		byKey[key] = &copied
+		merged = append(merged, &copied)
+	}
+	return merged
+}
+
+// Return a key which is identical for pipelines generating the same job,
+// regardless of their name and triggers.
+// Paused and active pipelines are never identical, since merging them would pause or resume one of them.
+func (p *Pipeline) jobKey() string {
+	workflow := p.asWorkflow()
+	workflow.Name = ""
+	workflow.On = WorkflowOn{}
+	for id, job := range workflow.Jobs {
+		job.Name = ""
+		workflow.Jobs[id] = job
+	}
+	key, err := json.Marshal(workflow)
+	if err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("paused=%t %s", p.Paused, key)
+}
+
+// Return a key which is identical for pipelines with the same settings,
+// regardless of their triggers and tags
+func (p *Pipeline) settingsKey() string {
+	copied := *p
+	copied.Triggers = WorkflowTriggers{}
+	copied.RawTriggers = nil
+	copied.Reusable = false
+	copied.Tags = nil
+	// Not serializable, and shared by all pipelines
+	copied.Settings.Repository = nil
+	key, err := json.Marshal(copied)
+	if err != nil {
+		panic(err)
+	}
+	return string(key)
+}
+
+// Combine the triggers of two registrations of the same job,
+// so that it runs when either of them matches.
+// Filters of an event can only be combined when both registrations filter on the same
+// dimensions (branches, paths...), and differ in at most one of them.
+// Otherwise the combined filter would be stricter or looser than both, so an error is returned.
+func (t WorkflowTriggers) union(other WorkflowTriggers) (WorkflowTriggers, error) {
+	result := t
+	switch {
+	case t.Push == nil:
+		result.Push = other.Push
+	case other.Push != nil:
+		filters, err := unionFilters("push",
+			filterDimension{"branches", t.Push.Branches, other.Push.Branches, false},
+			filterDimension{"tags", t.Push.Tags, other.Push.Tags, false},
+			filterDimension{"paths", t.Push.Paths, other.Push.Paths, false},
+			filterDimension{"branches-ignore", t.Push.BranchesIgnore, other.Push.BranchesIgnore, true},
+			filterDimension{"tags-ignore", t.Push.TagsIgnore, other.Push.TagsIgnore, true},
+			filterDimension{"paths-ignore", t.Push.PathsIgnore, other.Push.PathsIgnore, true},
+		)
+		if err != nil {
+			return t, err
+		}
+		result.Push = &PushEvent{
+			Branches:       filters[0],
+			Tags:           filters[1],
+			Paths:          filters[2],
+			BranchesIgnore: filters[3],
+			TagsIgnore:     filters[4],
+			PathsIgnore:    filters[5],
+		}
+	}
+	var err error
+	if result.PullRequest, err = unionPullRequestEvent("pull_request", t.PullRequest, other.PullRequest); err != nil {
+		return t, err
+	}
+	if result.PullRequestTarget, err = unionPullRequestEvent("pull_request_target", t.PullRequestTarget, other.PullRequestTarget); err != nil {
+		return t, err
+	}
+	result.Schedule = slices.Clone(t.Schedule)
+	for _, schedule := range other.Schedule {
+		if !slices.Contains(result.Schedule, schedule) {
+			result.Schedule = append(result.Schedule, schedule)
+		}
+	}
+	if result.WorkflowDispatch == nil {
+		result.WorkflowDispatch = other.WorkflowDispatch
+	}
+	if t.IssueComment == nil {
+		result.IssueComment = other.IssueComment
+	} else if other.IssueComment != nil {
+		result.IssueComment = &IssueCommentEvent{Types: unionTypes(t.IssueComment.Types, other.IssueComment.Types)}
+	}
+	if t.Issues == nil {
+		result.Issues = other.Issues
+	} else if other.Issues != nil {
+		result.Issues = &IssuesEvent{Types: unionTypes(t.Issues.Types, other.Issues.Types)}
+	}
+	switch {
+	case t.WorkflowRun == nil:
+		result.WorkflowRun = other.WorkflowRun
+	case other.WorkflowRun != nil:
+		filters, err := unionFilters("workflow_run",
+			filterDimension{"workflows", t.WorkflowRun.Workflows, other.WorkflowRun.Workflows, false},
+			filterDimension{"types", t.WorkflowRun.Types, other.WorkflowRun.Types, false},
+			filterDimension{"branches", t.WorkflowRun.Branches, other.WorkflowRun.Branches, false},
+		)
+		if err != nil {
+			return t, err
+		}
+		result.WorkflowRun = &WorkflowRunEvent{Workflows: filters[0], Types: filters[1], Branches: filters[2]}
+	}
+	if result.PageBuild == nil {
+		result.PageBuild = other.PageBuild
+	}
+	return result, nil
+}
+
+// Activity types of a pull request event, when none are specified
+var defaultPullRequestTypes = []string{"opened", "synchronize", "reopened"}
+
+func unionPullRequestEvent(event string, pr, other *PullRequestEvent) (*PullRequestEvent, error) {
+	if pr == nil {
+		return other, nil
+	}
+	if other == nil {
+		return pr, nil
+	}
+	// No types means the default types, not all types
+	types, otherTypes := pr.Types, other.Types
+	if len(types) == 0 && len(otherTypes) != 0 {
+		types = defaultPullRequestTypes
+	}
+	if len(otherTypes) == 0 && len(types) != 0 {
+		otherTypes = defaultPullRequestTypes
+	}
+	filters, err := unionFilters(event,
+		filterDimension{"types", types, otherTypes, false},
+		filterDimension{"branches", pr.Branches, other.Branches, false},
+		filterDimension{"paths", pr.Paths, other.Paths, false},
+		filterDimension{"branches-ignore", pr.BranchesIgnore, other.BranchesIgnore, true},
+		filterDimension{"paths-ignore", pr.PathsIgnore, other.PathsIgnore, true},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &PullRequestEvent{
+		Types:          filters[0],
+		Branches:       filters[1],
+		Paths:          filters[2],
+		BranchesIgnore: filters[3],
+		PathsIgnore:    filters[4],
+	}, nil
+}
+
+// Combine activity types of an event, where no types means all types
+func unionTypes(types, other []string) []string {
+	if len(types) == 0 || len(other) == 0 {
+		return nil
+	}
+	return appendUnique(slices.Clone(types), other...)
+}
+
+// A filter of an event, in both registrations being combined
+type filterDimension struct {
+	name          string
+	values, other []string
+	// Ignore filters skip the event, so they are combined by intersection
+	ignore bool
+}
+
+// Combine the filters of an event, and return the combined values of each dimension
+func unionFilters(event string, dimensions ...filterDimension) ([][]string, error) {
+	var differing []string
+	for _, dim := range dimensions {
+		if (len(dim.values) == 0) != (len(dim.other) == 0) {
+			return nil, fmt.Errorf("can't combine '%s' triggers: only one of them filters on '%s'", event, dim.name)
+		}
+		if !sameValues(dim.values, dim.other) {
+			differing = append(differing, dim.name)
+		}
+	}
+	if len(differing) > 1 {
+		return nil, fmt.Errorf("can't combine '%s' triggers: their '%s' filters are different", event, strings.Join(differing, "' and '"))
+	}
+	combined := make([][]string, len(dimensions))
+	for i, dim := range dimensions {
+		if dim.ignore {
+			for _, value := range dim.values {
+				if slices.Contains(dim.other, value) {
+					combined[i] = append(combined[i], value)
+				}
+			}
+			continue
+		}
+		combined[i] = appendUnique(slices.Clone(dim.values), dim.other...)
+	}
+	return combined, nil
+}
+
+// Check if two lists have the same values, in any order
+func sameValues(a, b []string) bool {
+	for _, value := range a {
+		if !slices.Contains(b, value) {
+			return false
+		}
+	}
+	for _, value := range b {
+		if !slices.Contains(a, value) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// A named output of a pipeline, extracted from the output of the dagger call
+type PipelineOutput struct {
+	Name string
+	// jq filter applied to the output, parsed as JSON
+	JsonPath string
+	// Key of a KEY=VALUE line of the output
+	Key string
+	// Description of the output, for callers of a reusable workflow
+	Description string
+}
+
+// Publish a named output of a pipeline's job, extracted from the output of the dagger call.
+// Downstream jobs can read it as 'needs.<job>.outputs.<name>', and callers of a reusable workflow
+// as 'jobs.<job>.outputs.<name>'.
+// Exactly one of jsonPath and key must be set.
+func (m *Gha) WithOutput(
+	// Name of the pipeline
+	pipeline string,
+	// Name of the output
+	name string,
+	// jq filter extracting the output from the dagger call output, parsed as JSON
+	// Example: ".image.digest"
+	// +optional
+	jsonPath string,
+	// Extract the output from the dagger call output, as the value of the last line in the form KEY=VALUE with this key.
+	// Other lines are ignored
+	// Example: "DIGEST"
+	// +optional
+	key string,
+	// Description of the output
+	// +optional
+	description string,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	if !regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_-]*$`).MatchString(name) {
+		return m, fmt.Errorf("invalid output name: '%s'", name)
+	}
+	if name == "stdout" || name == "stderr" {
+		return m, fmt.Errorf("invalid output name: '%s' is reserved", name)
+	}
+	if slices.ContainsFunc(p.Outputs, func(o PipelineOutput) bool { return o.Name == name }) {
+		return m, fmt.Errorf("pipeline '%s' already has an output '%s'", pipeline, name)
+	}
+	if (jsonPath == "") == (key == "") {
+		return m, fmt.Errorf("output '%s': exactly one of jsonPath and key must be set", name)
+	}
+	if strings.Contains(jsonPath, "\n") {
+		return m, fmt.Errorf("output '%s': jsonPath must be on a single line", name)
+	}
+	if key != "" && !regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_.-]*$`).MatchString(key) {
+		return m, fmt.Errorf("output '%s': invalid key '%s'", name, key)
+	}
+	p.Outputs = append(p.Outputs, PipelineOutput{
+		Name:        name,
+		JsonPath:    jsonPath,
+		Key:         key,
+		Description: description,
+	})
+	return m, nil
+}
+
+// Return the outputs of the pipeline's job
+func (p *Pipeline) jobOutputs() map[string]string {
+	outputs := map[string]string{
+		"stdout": "${{ steps.exec.outputs.stdout }}",
+		"stderr": "${{ steps.exec.outputs.stderr }}",
+	}
+	for _, output := range p.Outputs {
+		outputs[output.Name] = fmt.Sprintf("${{ steps.exec.outputs.%s }}", output.Name)
+	}
+	return outputs
+}
+
+// Encode the outputs for the exec script: one output per line, in the form "NAME json|key SPEC"
+func (p *Pipeline) outputsSpec() string {
+	var lines []string
+	for _, output := range p.Outputs {
+		if output.JsonPath != "" {
+			lines = append(lines, fmt.Sprintf("%s json %s", output.Name, output.JsonPath))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s key %s", output.Name, output.Key))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// An output of an upstream job, injected as an env variable in a pipeline
+type NeedsOutput struct {
+	// Job ID of the upstream pipeline
+	JobID string
+	// Name of the output
+	Output string
+	// Name of the env variable
+	Env string
+}
+
+// Inject an output of an upstream job of the same workflow as an env variable of a pipeline,
+// for example an image reference from a build job into a deploy job.
+// The command can reference it like any env variable, for example "deploy --image=$IMAGE_REF".
+// The upstream pipeline must be one of the jobs the pipeline needs.
+func (m *Gha) WithNeedsOutput(
+	// Name of the downstream pipeline
+	pipeline string,
+	// Name of the upstream pipeline
+	from string,
+	// Name of the upstream output. "stdout", "stderr", or an output declared with WithOutput
+	// Example: "image_ref"
+	output string,
+	// Name of the env variable. Defaults to the output name, upper-cased
+	// Example: "IMAGE_REF"
+	// +optional
+	env string,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	upstream := m.pipeline(from)
+	if upstream == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", from)
+	}
+	if p.Workflow == "" {
+		return m, fmt.Errorf("pipeline '%s' is not a job of another pipeline's workflow. See WithJob", pipeline)
+	}
+	if !slices.Contains(p.Needs, from) {
+		return m, fmt.Errorf("pipeline '%s' doesn't need pipeline '%s'. Add it to the needs of WithJob", pipeline, from)
+	}
+	if output != "stdout" && output != "stderr" &&
+		!slices.ContainsFunc(upstream.Outputs, func(o PipelineOutput) bool { return o.Name == output }) {
+		return m, fmt.Errorf("pipeline '%s' has no output '%s'. See WithOutput", from, output)
+	}
+	if env == "" {
+		env = strings.ToUpper(strings.ReplaceAll(output, "-", "_"))
+	}
+	if !regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`).MatchString(env) {
+		return m, fmt.Errorf("invalid env variable name: '%s'", env)
+	}
+	p.NeedsOutputs = append(p.NeedsOutputs, NeedsOutput{
+		JobID:  upstream.jobID(),
+		Output: output,
+		Env:    env,
+	})
+	return m, nil
+}
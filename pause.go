@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/shykes/gha/internal/dagger"
+)
+
+// Branch which pushes of paused pipelines are filtered on. It should never exist.
+const pausedBranch = "dagger-gha-paused/never-matches"
+
+// Return the triggers of a paused pipeline
+func pausedTriggers() WorkflowOn {
+	return WorkflowOn{
+		Push: &PushEvent{Branches: []string{pausedBranch}},
+	}
+}
+
+// Disable the workflow of a pipeline with the Github API, for example during an incident.
+// Its file is left unchanged.
+func (m *Gha) DisableWorkflow(
+	ctx context.Context,
+	// Name of the pipeline
+	name string,
+	// Github token with write access to the repository's actions
+	token *dagger.Secret,
+	// Repository of the workflow
+	// Example: "my-org/my-repo"
+	repository string,
+) error {
+	return m.setWorkflowState(ctx, name, token, repository, "disable")
+}
+
+// Enable the workflow of a pipeline with the Github API, after it was disabled
+func (m *Gha) EnableWorkflow(
+	ctx context.Context,
+	// Name of the pipeline
+	name string,
+	// Github token with write access to the repository's actions
+	token *dagger.Secret,
+	// Repository of the workflow
+	// Example: "my-org/my-repo"
+	repository string,
+) error {
+	return m.setWorkflowState(ctx, name, token, repository, "enable")
+}
+
+func (m *Gha) setWorkflowState(ctx context.Context, name string, token *dagger.Secret, repository, action string) error {
+	p := m.pipeline(name)
+	if p == nil {
+		return fmt.Errorf("no such pipeline: '%s'", name)
+	}
+	if p.Workflow != "" {
+		return fmt.Errorf("pipeline '%s' is a job of the workflow of pipeline '%s'", name, p.Workflow)
+	}
+	url := fmt.Sprintf("https://api.github.com/repos/%s/actions/workflows/%s/%s", repository, p.workflowFilename(), action)
+	_, err := githubAPIContainer(token).
+		// The workflow state can be changed elsewhere: don't cache it
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithExec([]string{"sh", "-c", `curl -fsS -X PUT ` + githubAPIHeaders + ` "$0"`, url}).
+		Sync(ctx)
+	return err
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 )
 
@@ -9,48 +10,89 @@ type Permission string
 
 type Permissions []Permission
 
-func (perms Permissions) JobPermissions() (p *JobPermissions) {
-	defer func() {
-		fmt.Printf("%v.JobPermissions() -> %v\n", perms, p)
-	}()
+func (perms Permissions) JobPermissions() *JobPermissions {
 	if perms == nil {
 		return nil
 	}
-	p = new(JobPermissions)
+	p := new(JobPermissions)
 	for _, perm := range perms {
-		object := perm.Object()
-		level := perm.Level()
-		fmt.Printf("applying permission '%s': object=%v level=%v\n", perm, object, level)
-		switch perm.Object() {
-		case "contents":
-			p.Contents = perm.Level()
-		case "issues":
-			p.Issues = perm.Level()
-		case "actions":
-			p.Actions = perm.Level()
-		case "packages":
-			p.Packages = perm.Level()
-		case "deployments":
-			p.Deployments = perm.Level()
-		case "pull_requests":
-			p.PullRequests = perm.Level()
-		case "pages":
-			p.Pages = perm.Level()
-		case "id_token":
-			p.IdToken = perm.Level()
-		case "repository_projects":
-			p.RepositoryProjects = perm.Level()
-		case "statuses":
-			p.Statuses = perm.Level()
-		case "metadata":
-			p.Metadata = perm.Level()
-		case "checks":
-			p.Checks = perm.Level()
-		case "discussions":
-			p.Discussions = perm.Level()
+		if field := p.field(perm.Object()); field != nil {
+			*field = perm.Level()
 		}
 	}
-	return
+	return p
+}
+
+// Return the field holding the level of a permission scope, or nil if the scope is unknown
+func (p *JobPermissions) field(object string) *PermissionLevel {
+	switch object {
+	case "contents":
+		return &p.Contents
+	case "issues":
+		return &p.Issues
+	case "actions":
+		return &p.Actions
+	case "attestations":
+		return &p.Attestations
+	case "packages":
+		return &p.Packages
+	case "deployments":
+		return &p.Deployments
+	case "pull_requests":
+		return &p.PullRequests
+	case "pages":
+		return &p.Pages
+	case "id_token":
+		return &p.IdToken
+	case "repository_projects":
+		return &p.RepositoryProjects
+	case "statuses":
+		return &p.Statuses
+	case "checks":
+		return &p.Checks
+	case "discussions":
+		return &p.Discussions
+	case "security_events":
+		return &p.SecurityEvents
+	case "models":
+		return &p.Models
+	}
+	return nil
+}
+
+// Levels of the scopes which don't accept all of read, write and none
+var scopeLevels = map[string][]PermissionLevel{
+	"id_token": {PermissionWrite, PermissionNone},
+	"models":   {PermissionRead, PermissionNone},
+}
+
+// Check that all permissions have a known scope, and a level allowed for it
+func (perms Permissions) check() error {
+	for _, perm := range perms {
+		if perm.Object() == "metadata" {
+			return fmt.Errorf("invalid permission '%s': metadata can't be set in a workflow, it is always readable", perm)
+		}
+		if new(JobPermissions).field(perm.Object()) == nil {
+			return fmt.Errorf("invalid permission '%s': unknown scope '%s'", perm, perm.Object())
+		}
+		levels, ok := scopeLevels[perm.Object()]
+		if !ok {
+			levels = []PermissionLevel{PermissionRead, PermissionWrite, PermissionNone}
+		}
+		if !slices.Contains(levels, perm.Level()) {
+			return fmt.Errorf("invalid permission '%s': level must be one of %v", perm, levels)
+		}
+	}
+	return nil
+}
+
+// Return the workflow-level permissions.
+// In deny-all mode, all permissions are denied at the workflow level, and granted per job.
+func (p *Pipeline) workflowPermissions() *JobPermissions {
+	if p.Settings.DenyAllPermissions {
+		return &JobPermissions{}
+	}
+	return nil
 }
 
 func (p Permission) parts() (PermissionLevel, string) {
@@ -77,6 +119,7 @@ const (
 	ReadContents            Permission = "read_contents"
 	ReadIssues              Permission = "read_issues"
 	ReadActions             Permission = "read_actions"
+	ReadAttestations        Permission = "read_attestations"
 	ReadPackages            Permission = "read_packages"
 	ReadDeployments         Permission = "read_deployments"
 	ReadPullRequests        Permission = "read_pull_requests"
@@ -87,9 +130,12 @@ const (
 	ReadMetadata            Permission = "read_metadata"
 	ReadChecks              Permission = "read_checks"
 	ReadDiscussions         Permission = "read_discussions"
+	ReadSecurityEvents      Permission = "read_security_events"
+	ReadModels              Permission = "read_models"
 	WriteContents           Permission = "write_contents"
 	WriteIssues             Permission = "write_issues"
 	WriteActions            Permission = "write_actions"
+	WriteAttestations       Permission = "write_attestations"
 	WritePackages           Permission = "write_packages"
 	WriteDeployments        Permission = "write_deployments"
 	WritePullRequests       Permission = "write_pull_requests"
@@ -100,4 +146,20 @@ const (
 	WriteMetadata           Permission = "write_metadata"
 	WriteChecks             Permission = "write_checks"
 	WriteDiscussions        Permission = "write_discussions"
+	WriteSecurityEvents     Permission = "write_security_events"
+	NoneContents            Permission = "none_contents"
+	NoneIssues              Permission = "none_issues"
+	NoneActions             Permission = "none_actions"
+	NoneAttestations        Permission = "none_attestations"
+	NonePackages            Permission = "none_packages"
+	NoneDeployments         Permission = "none_deployments"
+	NonePullRequests        Permission = "none_pull_requests"
+	NonePages               Permission = "none_pages"
+	NoneIdToken             Permission = "none_id_token"
+	NoneRepositoryProjects  Permission = "none_repository_projects"
+	NoneStatuses            Permission = "none_statuses"
+	NoneChecks              Permission = "none_checks"
+	NoneDiscussions         Permission = "none_discussions"
+	NoneSecurityEvents      Permission = "none_security_events"
+	NoneModels              Permission = "none_models"
 )
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Return the operating system of the pipeline's runner, guessed from its labels:
+// "linux", "macos" or "windows".
+// With a runner matrix, the jobs run on Linux runners unless all runners of the matrix have the same OS.
+func (p *Pipeline) runnerOS() string {
+	oses := p.runnerOSes()
+	if len(oses) == 1 {
+		return oses[0]
+	}
+	return "linux"
+}
+
+// Return the operating systems of the pipeline's runners
+func (p *Pipeline) runnerOSes() []string {
+	if runners := p.runnerMatrix(); runners != nil {
+		var oses []string
+		for _, runner := range runners {
+			oses = appendUnique(oses, labelsOS([]string{runner}))
+		}
+		return oses
+	}
+	return []string{labelsOS(p.Settings.Runner)}
+}
+
+// Return the operating system of a runner, guessed from its labels
+func labelsOS(labels []string) string {
+	for _, label := range labels {
+		label = strings.ToLower(label)
+		switch {
+		case strings.HasPrefix(label, "macos"):
+			return "macos"
+		case strings.HasPrefix(label, "windows"):
+			return "windows"
+		}
+	}
+	return "linux"
+}
+
+// Matrix key of the runners of a pipeline
+const runnerMatrixKey = "os"
+
+// Run a pipeline on each runner of a matrix, for example to validate it on Linux, macOS and Windows.
+// Jobs run on 'runs-on: ${{ matrix.os }}'. Engine steps which only work on Linux are skipped on other runners.
+func (m *Gha) WithRunnerMatrix(
+	// Name of the pipeline
+	pipeline string,
+	// Runner labels, one per job
+	// Example: ["ubuntu-latest", "macos-latest", "windows-latest"]
+	runners []string,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	if p.EphemeralRunner != nil {
+		return m, fmt.Errorf("pipeline '%s' runs on an ephemeral runner", pipeline)
+	}
+	if slices.ContainsFunc(p.Matrix, func(d MatrixDimension) bool { return d.Key == runnerMatrixKey }) && p.runnerMatrix() == nil {
+		return m, fmt.Errorf("pipeline '%s' already has a matrix key '%s'", pipeline, runnerMatrixKey)
+	}
+	if _, err := m.WithMatrix(pipeline, runnerMatrixKey, runners); err != nil {
+		return m, err
+	}
+	p.Settings.Runner = []string{"${{ matrix." + runnerMatrixKey + " }}"}
+	return m, nil
+}
+
+// Return the runners of the pipeline's runner matrix, if any
+func (p *Pipeline) runnerMatrix() []string {
+	if !slices.Equal(p.Settings.Runner, []string{"${{ matrix." + runnerMatrixKey + " }}"}) {
+		return nil
+	}
+	i := slices.IndexFunc(p.Matrix, func(d MatrixDimension) bool { return d.Key == runnerMatrixKey })
+	if i < 0 {
+		return nil
+	}
+	return p.Matrix[i].Values
+}
+
+// Steps which only work on Linux runners
+var linuxOnlySteps = []string{"start-engine", "warm-engine", "wait-engine", "capture-engine-logs", "stop-engine"}
+
+// Skip the Linux-only steps on the other runners of a runner matrix
+func (p *Pipeline) applyRunnerMatrix(steps []JobStep) []JobStep {
+	if len(p.runnerOSes()) < 2 {
+		return steps
+	}
+	for i, step := range steps {
+		if !slices.Contains(linuxOnlySteps, step.ID) {
+			continue
+		}
+		if step.If == "" {
+			steps[i].If = "runner.os == 'Linux'"
+		} else {
+			steps[i].If = andConditions(unwrapExpression(step.If), "runner.os == 'Linux'")
+		}
+	}
+	return steps
+}
+
+// Check that the pipeline's engine settings are supported by its runner.
+// The engine is only started and warmed up on Linux runners: on other runners, the CLI
+// provisions it on the first call, which requires a container runtime, or connects to a remote engine.
+func (p *Pipeline) checkRunnerOS() error {
+	for _, runnerOS := range p.runnerOSes() {
+		if runnerOS == "linux" {
+			continue
+		}
+		if p.Settings.EngineLogLevel != "" || p.Settings.CaptureEngineLogs {
+			return fmt.Errorf("the engine logs can only be configured or captured on Linux runners")
+		}
+		if runnerOS == "windows" && p.Settings.EngineHost == "" {
+			return fmt.Errorf("the engine can't run on Windows runners: set engineHost to connect to an engine on a Linux host")
+		}
+		if runnerOS == "macos" && p.Settings.EngineHost == "" && !slices.Contains(p.Settings.Runner, "self-hosted") {
+			fmt.Fprintf(os.Stderr,
+				"warning: pipeline '%s' runs on Github-hosted macOS runners, which have no container runtime. Set engineHost to connect to a remote engine\n",
+				p.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Named bundles of triggers, for the most common kinds of pipelines
+var triggerPresets = []string{"ci", "release", "nightly"}
+
+// Configure a pipeline's triggers from a named preset:
+//
+//   - ci: pull requests, and pushes to the default branch. Preempt older runs on the same pull request, unless another concurrency is set
+//   - release: pushes of semver tags
+//   - nightly: every night, and manual dispatch
+func (p *Pipeline) applyTriggerPreset(preset string) error {
+	switch preset {
+	case "ci":
+		p.onPullRequestAndPushToMain()
+		// Only replace the default, not an explicit setting
+		if p.Settings.PullRequestConcurrency == "" || p.Settings.PullRequestConcurrency == "allow" {
+			p.Settings.PullRequestConcurrency = "preempt"
+		}
+	case "release":
+		p.OnPush(nil, []string{"v[0-9]+.[0-9]+.[0-9]+*"}, nil, nil, nil, nil)
+	case "nightly":
+		p.OnSchedule([]string{"nightly"})
+		p.Triggers.WorkflowDispatch = &WorkflowDispatchEvent{}
+	default:
+		if slices.Contains(triggerPresets, strings.ToLower(preset)) {
+			return fmt.Errorf("unknown trigger preset '%s'. Did you mean '%s'?", preset, strings.ToLower(preset))
+		}
+		return fmt.Errorf("unknown trigger preset '%s'. Available presets: %s", preset, strings.Join(triggerPresets, ", "))
+	}
+	return nil
+}
+
+// Named runners, for the most common operating systems and architectures
+var runnerPresets = map[string][]string{
+	"linux-amd64":       {"ubuntu-24.04"},
+	"linux-arm64":       {"ubuntu-24.04-arm"},
+	"macos-arm64":       {"macos-15"},
+	"windows-amd64":     {"windows-2022"},
+	"self-hosted-amd64": {"self-hosted", "linux", "X64"},
+	"self-hosted-arm64": {"self-hosted", "linux", "ARM64"},
+	// Larger Github-hosted runners, with the default names suggested by Github.
+	// See https://docs.github.com/en/actions/using-github-hosted-runners/using-larger-runners
+	"linux-4-cores":  {"ubuntu-latest-4-cores"},
+	"linux-8-cores":  {"ubuntu-latest-8-cores"},
+	"linux-16-cores": {"ubuntu-latest-16-cores"},
+	"linux-32-cores": {"ubuntu-latest-32-cores"},
+	"linux-64-cores": {"ubuntu-latest-64-cores"},
+}
+
+// Sizes of larger Github-hosted runners, in CPU cores
+var runnerSizes = []int{2, 4, 8, 16, 32, 64, 96}
+
+// Run a pipeline on a larger Github-hosted runner, with the given number of CPU cores.
+// Larger runners are named by the organization that creates them: by default, the names
+// suggested by Github are used.
+// See https://docs.github.com/en/actions/using-github-hosted-runners/using-larger-runners
+func (m *Gha) WithRunnerSize(
+	// Name of the pipeline
+	pipeline string,
+	// Number of CPU cores
+	// Possible values: 2, 4, 8, 16, 32, 64, 96
+	cores int,
+	// Name of the runner, where {cores} is replaced with the number of cores
+	// +optional
+	// +default="ubuntu-latest-{cores}-cores"
+	nameTemplate string,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	if !slices.Contains(runnerSizes, cores) {
+		return m, fmt.Errorf("unsupported runner size: %d cores", cores)
+	}
+	if !strings.Contains(nameTemplate, "{cores}") {
+		return m, fmt.Errorf("invalid runner name template '%s': it must contain {cores}", nameTemplate)
+	}
+	p.Settings.Runner = []string{strings.ReplaceAll(nameTemplate, "{cores}", strconv.Itoa(cores))}
+	return m, nil
+}
+
+// Return the labels of a runner preset
+func runnerPresetLabels(preset string) ([]string, error) {
+	labels, ok := runnerPresets[preset]
+	if !ok {
+		return nil, fmt.Errorf("unknown runner preset '%s'. Available presets: %s", preset, strings.Join(sortedKeys(runnerPresets), ", "))
+	}
+	// Don't let pipelines modify the preset
+	return slices.Clone(labels), nil
+}
+
+// Run on pull requests, and on pushes to the default branch only.
+// Pushes to pull request branches are already covered by the pull_request trigger,
+// so running on all pushes would run the pipeline twice for each commit.
+func (p *Pipeline) onPullRequestAndPushToMain() *Pipeline {
+	p.OnPullRequest(nil, nil, nil, nil, nil)
+	p.OnPush([]string{p.Settings.defaultBranch()}, nil, nil, nil, nil, nil)
+	return p
+}
+
+// Warn about pipelines which run twice for each commit pushed to a pull request branch
+func (p *Pipeline) checkDuplicateRuns() {
+	push, pr := p.Triggers.Push, p.Triggers.PullRequest
+	if push == nil || pr == nil {
+		return
+	}
+	if len(push.Branches) > 0 || len(push.Tags) > 0 || len(push.BranchesIgnore) > 0 || len(pr.Types) > 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr,
+		"warning: pipeline '%s' runs on all pushes and on pull requests, so it runs twice for each commit pushed to a pull request. Consider onPullRequestAndPushToMain\n",
+		p.Name)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shykes/gha/internal/dagger"
+)
+
+// Required status checks of a protected branch, in the format of the Github API
+// See https://docs.github.com/en/rest/branches/branch-protection#update-status-check-protection
+type RequiredStatusChecks struct {
+	Strict bool                  `json:"strict"`
+	Checks []RequiredStatusCheck `json:"checks"`
+}
+
+type RequiredStatusCheck struct {
+	Context string `json:"context"`
+}
+
+// Return the names of the checks which pipelines report on pull requests.
+// They are the names of the generated jobs, with the values of each matrix combination.
+// Paused pipelines, reusable workflow calls, and jobs whose names can only be known
+// when the workflow runs are skipped, since requiring them would block pull requests.
+func (m *Gha) RequiredChecks(
+	// Only include pipelines with at least one of these tags
+	// +optional
+	onlyTags []string,
+) []string {
+	var checks []string
+	for _, p := range m.workflowPipelines(m.selectPipelines(onlyTags)) {
+		if p.Workflow != "" || p.Paused || (p.Triggers.PullRequest == nil && p.Triggers.PullRequestTarget == nil) {
+			continue
+		}
+		pipelines := append([]*Pipeline{p}, m.workflowJobs(p)...)
+		for id, job := range m.asWorkflow(p).Jobs {
+			if job.Uses != "" {
+				// Reported as 'caller / callee', with the job names of the called workflow
+				continue
+			}
+			if strings.Contains(job.Name, "${{") {
+				fmt.Fprintf(os.Stderr, "warning: pipeline '%s': job '%s' has a dynamic name, and can't be a required check\n", p.Name, id)
+				continue
+			}
+			i := slices.IndexFunc(pipelines, func(q *Pipeline) bool { return q.jobID() == id })
+			if i < 0 || job.Strategy == nil {
+				checks = appendUnique(checks, job.Name)
+				continue
+			}
+			if pipelines[i].DynamicMatrix != nil {
+				fmt.Fprintf(os.Stderr, "warning: pipeline '%s': job '%s' has a dynamic matrix, and can't be a required check\n", p.Name, id)
+				continue
+			}
+			for _, combination := range pipelines[i].matrixCombinations() {
+				checks = appendUnique(checks, fmt.Sprintf("%s (%s)", job.Name, strings.Join(combination, ", ")))
+			}
+		}
+	}
+	slices.Sort(checks)
+	return checks
+}
+
+// Return the values of each combination of the pipeline's matrix, in the order of the matrix keys.
+// Combinations which may be excluded are skipped.
+func (p *Pipeline) matrixCombinations() [][]string {
+	dimensions := slices.Clone(p.Matrix)
+	// Matrix keys are serialized in alphabetical order
+	slices.SortFunc(dimensions, func(a, b MatrixDimension) int { return strings.Compare(a.Key, b.Key) })
+	var exclusions []map[string]string
+	for _, exclusion := range p.MatrixExclusions {
+		combination, _ := parseEnv(exclusion.Combination)
+		exclusions = append(exclusions, combination)
+	}
+	combinations := [][]string{nil}
+	for _, dimension := range dimensions {
+		var next [][]string
+		for _, combination := range combinations {
+			for _, value := range dimension.Values {
+				next = append(next, append(slices.Clone(combination), value))
+			}
+		}
+		combinations = next
+	}
+	return slices.DeleteFunc(combinations, func(combination []string) bool {
+		return slices.ContainsFunc(exclusions, func(exclusion map[string]string) bool {
+			for i, dimension := range dimensions {
+				if value, ok := exclusion[dimension.Key]; ok && value != combination[i] {
+					return false
+				}
+			}
+			return true
+		})
+	})
+}
+
+// Return the API payload which makes the pipelines' checks required on a protected branch
+func (m *Gha) BranchProtectionPayload(
+	// Require branches to be up to date with the base branch before merging
+	// +optional
+	strict bool,
+	// Only include pipelines with at least one of these tags
+	// +optional
+	onlyTags []string,
+) (string, error) {
+	payload := RequiredStatusChecks{
+		Strict: strict,
+		Checks: []RequiredStatusCheck{},
+	}
+	for _, check := range m.RequiredChecks(onlyTags) {
+		payload.Checks = append(payload.Checks, RequiredStatusCheck{Context: check})
+	}
+	contents, err := json.MarshalIndent(payload, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(contents), nil
+}
+
+// Make the pipelines' checks required on a protected branch, so renaming a pipeline
+// doesn't silently drop it from the required checks.
+// The branch must already be protected. Checks which are not generated by a pipeline are removed.
+func (m *Gha) ConfigureBranchProtection(
+	ctx context.Context,
+	// Github token with administration permission on the repository
+	token *dagger.Secret,
+	// Repository to configure
+	// Example: "my-org/my-repo"
+	repository string,
+	// Protected branch. Defaults to the default branch
+	// +optional
+	branch string,
+	// Require branches to be up to date with the base branch before merging
+	// +optional
+	strict bool,
+	// Only include pipelines with at least one of these tags
+	// +optional
+	onlyTags []string,
+) (string, error) {
+	payload, err := m.BranchProtectionPayload(strict, onlyTags)
+	if err != nil {
+		return "", err
+	}
+	if branch == "" {
+		branch = m.Settings.defaultBranch()
+	}
+	url := fmt.Sprintf("https://api.github.com/repos/%s/branches/%s/protection/required_status_checks", repository, branch)
+	return githubAPIContainer(token).
+		// The settings can be changed elsewhere: don't cache them
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithNewFile("/payload.json", payload).
+		WithExec([]string{"sh", "-c",
+			`curl -fsS -X PATCH ` +
+				githubAPIHeaders + ` -d @/payload.json "$0"`,
+			url,
+		}).
+		Stdout(ctx)
+}
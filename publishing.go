@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Registries supported by trusted publishing
+var trustedPublishers = []string{"pypi", "testpypi", "npm"}
+
+// Publish to a package registry with OIDC-based trusted publishing, instead of a long-lived token.
+// The pipeline's job is granted the 'id-token: write' permission, and:
+//
+//   - pypi, testpypi: a short-lived API token is minted right before the command runs (and before each retry),
+//     and exported as PYPI_TOKEN. Pass it to the publish function with 'env:PYPI_TOKEN'.
+//     PyPI tokens expire after 15 minutes: for longer pipelines, publish from a separate, shorter pipeline.
+//   - npm: NPM_CONFIG_PROVENANCE is set, and the publish function can request OIDC tokens with
+//     'env:ACTIONS_ID_TOKEN_REQUEST_URL' and 'env:ACTIONS_ID_TOKEN_REQUEST_TOKEN'.
+//
+// The repository and workflow must be registered as a trusted publisher on the registry.
+// See https://docs.pypi.org/trusted-publishers/ and https://docs.npmjs.com/trusted-publishers
+func (m *Gha) WithTrustedPublishing(
+	// Name of the pipeline
+	pipeline string,
+	// Registry to publish to
+	// Possible values: "pypi", "testpypi", "npm"
+	registry string,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	if !slices.Contains(trustedPublishers, registry) {
+		return m, fmt.Errorf("unsupported trusted publishing registry: '%s'. Possible values: %s", registry, strings.Join(trustedPublishers, ", "))
+	}
+	p.TrustedPublishing = registry
+	perms := slices.Clone(p.Settings.Permissions)
+	if perms == nil {
+		// Declaring a permission denies the others, but the job still needs to checkout the repository
+		perms = Permissions{ReadContents}
+	}
+	p.Settings.Permissions = append(perms, WriteIdToken)
+	return m, nil
+}
+
+// Add the env variables of trusted publishing, if any, to the env of the exec step
+func (p *Pipeline) trustedPublishingEnv(env map[string]string) {
+	switch p.TrustedPublishing {
+	case "pypi":
+		env["PYPI_URL"] = "https://pypi.org"
+	case "testpypi":
+		env["PYPI_URL"] = "https://test.pypi.org"
+	case "npm":
+		env["NPM_CONFIG_PROVENANCE"] = "true"
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/shykes/gha/internal/dagger"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// Create or update a Github Actions secret, for example one declared by a new pipeline.
+// The value is encrypted with the public key of the repository or organization before it's sent.
+// Exactly one of repository and organization must be set.
+func (m *Gha) PushSecret(
+	ctx context.Context,
+	// Name of the secret
+	name string,
+	// Value of the secret
+	value *dagger.Secret,
+	// Github token allowed to manage the secrets
+	token *dagger.Secret,
+	// Repository of the secret
+	// Example: "my-org/my-repo"
+	// +optional
+	repository string,
+	// Organization of the secret, for an organization secret
+	// +optional
+	organization string,
+	// Visibility of an organization secret
+	// Possible values: "all", "private"
+	// +optional
+	// +default="private"
+	visibility string,
+) error {
+	if !regexp.MustCompile(`^[a-zA-Z0-9_]+$`).MatchString(name) {
+		return fmt.Errorf("invalid secret name: '%s' must contain only alphanumeric characters and underscores", name)
+	}
+	var endpoint string
+	switch {
+	case repository != "" && organization == "":
+		endpoint = "https://api.github.com/repos/" + repository + "/actions/secrets"
+	case organization != "" && repository == "":
+		endpoint = "https://api.github.com/orgs/" + organization + "/actions/secrets"
+	default:
+		return fmt.Errorf("exactly one of repository and organization must be set")
+	}
+	ctr := githubAPIContainer(token).
+		// The public key can be rotated: don't cache it
+		WithEnvVariable("CACHE_BUSTER", time.Now().String())
+	// Fetch the public key to encrypt the secret with
+	out, err := ctr.
+		WithExec([]string{"sh", "-c", `curl -fsS ` + githubAPIHeaders + ` "$0"`, endpoint + "/public-key"}).
+		Stdout(ctx)
+	if err != nil {
+		return err
+	}
+	var publicKey struct {
+		KeyID string `json:"key_id"`
+		Key   string `json:"key"`
+	}
+	if err := json.Unmarshal([]byte(out), &publicKey); err != nil {
+		return fmt.Errorf("invalid public key: %w", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(publicKey.Key)
+	if err != nil || len(key) != 32 {
+		return fmt.Errorf("invalid public key: '%s'", publicKey.Key)
+	}
+	plaintext, err := value.Plaintext(ctx)
+	if err != nil {
+		return err
+	}
+	// Github secrets are encrypted with libsodium sealed boxes
+	encrypted, err := box.SealAnonymous(nil, []byte(plaintext), (*[32]byte)(key), rand.Reader)
+	if err != nil {
+		return err
+	}
+	body := map[string]string{
+		"encrypted_value": base64.StdEncoding.EncodeToString(encrypted),
+		"key_id":          publicKey.KeyID,
+	}
+	if organization != "" {
+		body["visibility"] = visibility
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	_, err = ctr.
+		WithNewFile("/payload.json", string(payload)).
+		WithExec([]string{"sh", "-c", `curl -fsS -X PUT ` + githubAPIHeaders + ` -d @/payload.json "$0"`, endpoint + "/" + name}).
+		Sync(ctx)
+	return err
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Events modeled by WorkflowTriggers
+var knownTriggers = []string{"push", "pull_request", "pull_request_target", "schedule", "workflow_dispatch", "issue_comment", "issues", "workflow_run", "page_build"}
+
+// Merge an arbitrary 'on:' block into a pipeline's triggers.
+// This is an escape hatch for events which are not modeled yet.
+func (m *Gha) WithRawTrigger(
+	// Name of the pipeline
+	pipeline string,
+	// YAML snippet in the format of a workflow's 'on:' block
+	// Example: "merge_group:\n  types: [checks_requested]"
+	trigger string,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	var events map[string]yaml.Node
+	if err := yaml.Unmarshal([]byte(trigger), &events); err != nil {
+		return m, fmt.Errorf("invalid raw trigger: %w", err)
+	}
+	known := map[string]yaml.Node{}
+	raw := map[string]yaml.Node{}
+	for event, value := range events {
+		switch {
+		case event == "workflow_call":
+			return m, fmt.Errorf("invalid raw trigger: use onWorkflowCall for reusable workflows")
+		case slices.Contains(knownTriggers, event):
+			known[event] = value
+		default:
+			raw[event] = value
+		}
+	}
+	// Modeled events are merged with the existing triggers
+	if len(known) > 0 {
+		contents, err := yaml.Marshal(known)
+		if err != nil {
+			return m, err
+		}
+		triggers, inputs, err := parseTriggers(string(contents))
+		if err != nil {
+			return m, err
+		}
+		p.Triggers.merge(triggers)
+		if err := p.addDispatchInputs(inputs); err != nil {
+			return m, fmt.Errorf("pipeline '%s': %w", pipeline, err)
+		}
+		if err := p.checkTriggers(); err != nil {
+			return m, fmt.Errorf("pipeline '%s': %w", pipeline, err)
+		}
+	}
+	// Other events are stored as-is. The Dagger API can't serialize maps.
+	if len(raw) > 0 {
+		contents, err := yaml.Marshal(raw)
+		if err != nil {
+			return m, err
+		}
+		p.RawTriggers = append(p.RawTriggers, string(contents))
+	}
+	return m, nil
+}
+
+// Return the raw triggers of the pipeline, merged into a single map
+func (p *Pipeline) rawTriggers() map[string]any {
+	if len(p.RawTriggers) == 0 {
+		return nil
+	}
+	triggers := map[string]any{}
+	for _, raw := range p.RawTriggers {
+		var events map[string]any
+		if err := yaml.Unmarshal([]byte(raw), &events); err != nil {
+			// Raw triggers are validated when added
+			panic(err)
+		}
+		for event, value := range events {
+			triggers[event] = value
+		}
+	}
+	return triggers
+}
+
+// Positions in the generated job where raw steps can be inserted
+var rawStepPositions = []string{"start", "after-checkout", "before-exec", "after-exec", "end"}
+
+// A step defined in raw YAML, inserted at a named position in the job
+type RawStep struct {
+	Position string
+	Step     string
+}
+
+// Insert a step defined in raw YAML in a pipeline's job.
+// This is an escape hatch for step types which are not modeled yet.
+func (m *Gha) WithRawStep(
+	// Name of the pipeline
+	pipeline string,
+	// Position of the step in the job
+	// Possible values: "start", "after-checkout", "before-exec", "after-exec", "end"
+	position string,
+	// YAML snippet defining the step
+	// Example: "uses: actions/setup-node@v4\nwith:\n  node-version: 20"
+	step string,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	if !slices.Contains(rawStepPositions, position) {
+		return m, fmt.Errorf("invalid position for raw step: '%s'. Possible values: %s", position, strings.Join(rawStepPositions, ", "))
+	}
+	if _, err := parseRawStep(step); err != nil {
+		return m, err
+	}
+	p.RawSteps = append(p.RawSteps, RawStep{Position: position, Step: step})
+	return m, nil
+}
+
+// Insert a step in a pipeline's job
+func (m *Gha) WithStep(
+	// Name of the pipeline
+	pipeline string,
+	// Position of the step in the job
+	// Possible values: "start", "after-checkout", "before-exec", "after-exec", "end"
+	position string,
+	// Name of the step
+	// +optional
+	name string,
+	// ID of the step, to reference its outputs
+	// +optional
+	id string,
+	// Action to run
+	// Example: "docker://alpine:3.20"
+	// +optional
+	uses string,
+	// Shell command to run
+	// +optional
+	run string,
+	// Inputs of the action, in the form KEY=VALUE
+	// +optional
+	with []string,
+	// Entrypoint of a docker action, overriding the image's ENTRYPOINT
+	// +optional
+	entrypoint string,
+	// Arguments of a docker action, overriding the image's CMD
+	// +optional
+	args string,
+	// Env variables of the step, in the form KEY=VALUE
+	// +optional
+	env []string,
+	// Only run the step when this expression is true
+	// Example: "failure()"
+	// +optional
+	condition string,
+	// Don't fail the job when the step fails
+	// +optional
+	continueOnError bool,
+	// Working directory of the 'run' command
+	// +optional
+	workingDirectory string,
+	// Maximum number of minutes to run the step
+	// +optional
+	timeoutMinutes int,
+) (*Gha, error) {
+	if (uses == "") == (run == "") {
+		return m, fmt.Errorf("a step must either use an action, or run a command")
+	}
+	inputs, err := parseEnv(with)
+	if err != nil {
+		return m, fmt.Errorf("invalid step input: %w", err)
+	}
+	if entrypoint != "" {
+		inputs["entrypoint"] = entrypoint
+	}
+	if args != "" {
+		inputs["args"] = args
+	}
+	stepEnv, err := parseEnv(env)
+	if err != nil {
+		return m, err
+	}
+	step := JobStep{
+		Name:             name,
+		ID:               id,
+		If:               unwrapExpression(condition),
+		Uses:             uses,
+		Run:              run,
+		With:             inputs,
+		Env:              stepEnv,
+		TimeoutMinutes:   timeoutMinutes,
+		ContinueOnError:  continueOnError,
+		WorkingDirectory: workingDirectory,
+	}
+	contents, err := yaml.Marshal(step)
+	if err != nil {
+		return m, err
+	}
+	return m.WithRawStep(pipeline, position, string(contents))
+}
+
+// Parse a step defined in raw YAML. Fields which are not modeled by JobStep are kept as-is.
+func parseRawStep(step string) (JobStep, error) {
+	var parsed JobStep
+	if err := yaml.Unmarshal([]byte(step), &parsed); err != nil {
+		return parsed, fmt.Errorf("invalid raw step: %w", err)
+	}
+	return parsed, nil
+}
+
+// Return the raw steps to insert at the given position
+func (p *Pipeline) rawSteps(position string) []JobStep {
+	var steps []JobStep
+	for _, raw := range p.RawSteps {
+		if raw.Position != position {
+			continue
+		}
+		step, err := parseRawStep(raw.Step)
+		if err != nil {
+			// Raw steps are validated when added
+			panic(err)
+		}
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+// Encode triggers as JSON, including raw triggers
+func (on WorkflowOn) MarshalJSON() ([]byte, error) {
+	type workflowOn WorkflowOn
+	return marshalJSONWithRaw(workflowOn(on), on.Raw)
+}
+
+// Encode a step as JSON, including raw fields
+func (step JobStep) MarshalJSON() ([]byte, error) {
+	type jobStep JobStep
+	return marshalJSONWithRaw(jobStep(step), step.Raw)
+}
+
+// Encode a value as a JSON object, with extra raw fields.
+// encoding/json can't inline maps, so they are spliced in manually.
+func marshalJSONWithRaw(v any, raw map[string]any) ([]byte, error) {
+	contents, err := json.Marshal(v)
+	if err != nil || len(raw) == 0 {
+		return contents, err
+	}
+	extra, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	contents = bytes.TrimSuffix(contents, []byte("}"))
+	if len(contents) > 1 {
+		contents = append(contents, ',')
+	}
+	return append(contents, extra[1:]...), nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/shykes/gha/internal/dagger"
+)
+
+// Generate a README listing the generated workflows
+func (m *Gha) readme() *dagger.Directory {
+	if !m.Settings.Readme {
+		return dag.Directory()
+	}
+	var doc strings.Builder
+	fmt.Fprintf(&doc, "<!-- %s -->\n", genMessage)
+	if banner := m.Settings.Banner; banner != "" {
+		fmt.Fprintf(&doc, "<!--\n%s-->\n", commentBlock(banner, ""))
+	}
+	doc.WriteString("\n")
+	doc.WriteString("# Workflows\n\n")
+	doc.WriteString("The workflows below are generated from Dagger pipelines. Do not edit them by hand.\n")
+	if cmd := m.Settings.RegenerateCommand; cmd != "" {
+		fmt.Fprintf(&doc, "\nTo regenerate them, run:\n\n```bash\n%s\n```\n", cmd)
+	}
+	for _, p := range m.workflowPipelines(m.Pipelines) {
+		if p.Workflow != "" {
+			continue
+		}
+		fmt.Fprintf(&doc, "\n## [%s](%s)\n\n", p.Name, p.workflowFilename())
+		if p.Paused {
+			doc.WriteString("- Paused\n")
+		} else {
+			fmt.Fprintf(&doc, "- Triggers: %s\n", strings.Join(p.workflowOn().events(), ", "))
+		}
+		if p.Module != "" {
+			fmt.Fprintf(&doc, "- Module: `%s`\n", p.Module)
+		}
+		fmt.Fprintf(&doc, "- Command: `dagger call %s`\n", p.Command)
+		for _, call := range p.Calls {
+			if call.Module != "" {
+				fmt.Fprintf(&doc, "- Then: `dagger call -m %s %s`\n", call.Module, call.Command)
+			} else {
+				fmt.Fprintf(&doc, "- Then: `dagger call %s`\n", call.Command)
+			}
+		}
+		for _, job := range m.workflowJobs(p) {
+			fmt.Fprintf(&doc, "- Job %s: `dagger call %s`\n", job.Name, job.Command)
+		}
+	}
+	return dag.
+		Directory().
+		WithNewFile(".github/workflows/README.md", doc.String())
+}
+
+// Return the names of the events which trigger the workflow
+func (on WorkflowOn) events() []string {
+	contents, err := json.Marshal(on)
+	if err != nil {
+		panic(err)
+	}
+	var events map[string]json.RawMessage
+	if err := json.Unmarshal(contents, &events); err != nil {
+		panic(err)
+	}
+	names := make([]string, 0, len(events))
+	for name := range events {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
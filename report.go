@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Add a pipeline which produces a report on a schedule, for example of outdated dependencies or known vulnerabilities.
+// The output of the command is published in the job summary, and uploaded as an artifact.
+func (m *Gha) WithReportPipeline(
+	// Pipeline name
+	name string,
+	// The Dagger command to execute. Its output should be a report in markdown format
+	// Example: "scan-dependencies --source=."
+	command string,
+	// The Dagger module to load
+	// +optional
+	module string,
+	// Github secrets to inject into the pipeline environment
+	// +optional
+	secrets []string,
+	// When to produce the report, with cron expressions or presets
+	// +optional
+	// +default=["weekly(mon, 6)"]
+	schedule []string,
+	// Name of the artifact holding the report.
+	// It is suffixed with the job ID and matrix index, to be unique in the run
+	// +optional
+	// +default="report"
+	artifactName string,
+) (*Gha, error) {
+	p := &Pipeline{
+		Name:           name,
+		Command:        command,
+		Module:         module,
+		Secrets:        secrets,
+		Settings:       m.Settings,
+		ReportArtifact: artifactName,
+	}
+	// Reports can also be produced on demand
+	p.Triggers.WorkflowDispatch = &WorkflowDispatchEvent{}
+	p.OnSchedule(schedule)
+	if err := p.checkSchedule(); err != nil {
+		return m, fmt.Errorf("pipeline '%s': %w", name, err)
+	}
+	return m.addPipeline(p)
+}
+
+// Path of the report file on the runner
+func (p *Pipeline) reportFile() string {
+	return fmt.Sprintf("${{ runner.temp }}/dagger-report/%s.md", p.ReportArtifact)
+}
+
+func (p *Pipeline) uploadReportStep() JobStep {
+	return JobStep{
+		Name: "Upload report",
+		If:   "always()",
+		Uses: "actions/upload-artifact@v4",
+		With: map[string]string{
+			"name":              p.artifactName(p.ReportArtifact),
+			"path":              p.reportFile(),
+			"if-no-files-found": "ignore",
+		},
+	}
+}
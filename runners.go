@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// An ephemeral self-hosted runner, provisioned for a single run of a pipeline
+type EphemeralRunner struct {
+	// Dagger command provisioning the machine of the runner
+	Provision string
+	// Dagger command destroying the machine of the runner
+	Teardown string
+	// Labels of the runner, in addition to the unique label of the run
+	Labels []string
+	// Name of the Github secret holding a token allowed to register runners
+	TokenSecret string
+	// ID of the runner group to register the runner in
+	RunnerGroupID int
+}
+
+// Job IDs of the jobs managing ephemeral runners
+const (
+	provisionRunnerJobID = "provision-runner"
+	teardownRunnerJobID  = "teardown-runner"
+)
+
+// Run a pipeline on an ephemeral self-hosted runner, provisioned for each run.
+// A just-in-time runner configuration is generated with the Github API, and passed to the
+// provision command as RUNNER_JIT_CONFIG, for example with '--jit-config=env:RUNNER_JIT_CONFIG'.
+// The provision and teardown commands get the pipeline's secrets and variables, RUNNER_NAME and RUNNER_LABELS.
+// The runner unregisters itself after running the pipeline's job. If the job never runs,
+// it is removed after the teardown command.
+// See https://docs.github.com/en/actions/hosting-your-own-runners/managing-self-hosted-runners/autoscaling-with-self-hosted-runners#using-just-in-time-runners
+func (m *Gha) WithEphemeralRunner(
+	// Name of the pipeline
+	pipeline string,
+	// Dagger command starting a machine which runs the runner, from the pipeline's module
+	// Example: "provision --jit-config=env:RUNNER_JIT_CONFIG --name=$RUNNER_NAME"
+	provision string,
+	// Dagger command destroying the machine, from the pipeline's module. It runs even if the pipeline fails
+	// Example: "teardown --name=$RUNNER_NAME"
+	// +optional
+	teardown string,
+	// Labels of the runner. A label unique to the run is added, so the job can't run on another runner
+	// +optional
+	// +default=["self-hosted", "linux", "x64"]
+	labels []string,
+	// Name of the Github secret holding a token allowed to administrate the repository's runners
+	// +optional
+	// +default="RUNNER_ADMIN_TOKEN"
+	tokenSecret string,
+	// ID of the runner group to register the runner in
+	// +optional
+	// +default=1
+	runnerGroupId int,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	if p.Workflow != "" {
+		return m, fmt.Errorf("pipeline '%s' is a job of the workflow of pipeline '%s'", pipeline, p.Workflow)
+	}
+	p.EphemeralRunner = &EphemeralRunner{
+		Provision:     provision,
+		Teardown:      teardown,
+		Labels:        labels,
+		TokenSecret:   tokenSecret,
+		RunnerGroupID: runnerGroupId,
+	}
+	if err := p.checkEphemeralRunner(); err != nil {
+		return m, fmt.Errorf("pipeline '%s': %w", pipeline, err)
+	}
+	return m, nil
+}
+
+// Check that the pipeline can run on a single ephemeral runner.
+// A matrix can be added after the runner, so this is checked again at generation time.
+func (p *Pipeline) checkEphemeralRunner() error {
+	if p.EphemeralRunner == nil {
+		return nil
+	}
+	if len(p.Matrix) > 0 || p.DynamicMatrix != nil {
+		return fmt.Errorf("an ephemeral runner can't run a matrix: each job would need its own runner")
+	}
+	return nil
+}
+
+// Label unique to a run, so its job only runs on the runner provisioned for it
+const ephemeralRunnerLabel = "dagger-${{ github.run_id }}-${{ github.run_attempt }}"
+
+// Run the job of a pipeline on an ephemeral runner, provisioned and destroyed by additional jobs
+func (p *Pipeline) withEphemeralRunner(workflow Workflow) Workflow {
+	runner := p.EphemeralRunner
+	if runner == nil {
+		return workflow
+	}
+	job := workflow.Jobs[p.jobID()]
+	job.RunsOn = append(slices.Clone(runner.Labels), ephemeralRunnerLabel)
+	job.Needs = append(job.Needs, provisionRunnerJobID)
+	workflow.Jobs[p.jobID()] = job
+	// The provision and teardown jobs run on the default runner, with the same
+	// secrets, variables and Dagger Cloud token as the pipeline
+	env := map[string]string{
+		"RUNNER_NAME":   ephemeralRunnerLabel,
+		"RUNNER_LABELS": strings.Join(job.RunsOn, ","),
+	}
+	if p.Settings.Debug {
+		env["DEBUG"] = "1"
+	}
+	p.secretsEnv(env)
+	p.daggerEnv(env)
+	token := fmt.Sprintf("${{ secrets.%s }}", p.githubSecret(runner.TokenSecret))
+	jitConfig := p.bashStep("jit-config", map[string]string{
+		"RUNNER_NAME":     ephemeralRunnerLabel,
+		"RUNNER_LABELS":   strings.Join(job.RunsOn, ","),
+		"RUNNER_GROUP_ID": strconv.Itoa(runner.RunnerGroupID),
+		"RUNNER_TOKEN":    token,
+	})
+	provision := p.bashStep("exec", splitLargeEnv(mergeEnv(env, map[string]string{
+		"COMMAND":           "dagger call -q " + runner.Provision,
+		"RUNNER_JIT_CONFIG": "${{ steps.jit-config.outputs.jit-config }}",
+	})))
+	var steps []JobStep
+	steps = append(steps, p.installDaggerSteps()...)
+	steps = append(steps, p.checkoutStep(), jitConfig, provision)
+	workflow.Jobs[provisionRunnerJobID] = Job{
+		Name:    p.Name + " (provision runner)",
+		RunsOn:  p.Settings.Runner,
+		If:      job.If,
+		Steps:   steps,
+		Outputs: map[string]string{"runner-id": "${{ steps.jit-config.outputs.runner-id }}"},
+	}
+	steps = nil
+	if runner.Teardown != "" {
+		steps = append(steps, p.installDaggerSteps()...)
+		steps = append(steps, p.checkoutStep())
+		teardown := p.bashStep("exec", splitLargeEnv(mergeEnv(env, map[string]string{
+			"COMMAND": "dagger call -q " + runner.Teardown,
+		})))
+		teardown.If = "always()"
+		steps = append(steps, teardown)
+	}
+	remove := p.bashStep("remove-runner", map[string]string{
+		"RUNNER_ID":    fmt.Sprintf("${{ needs.%s.outputs.runner-id }}", provisionRunnerJobID),
+		"RUNNER_TOKEN": token,
+	})
+	remove.If = "always()"
+	steps = append(steps, remove)
+	workflow.Jobs[teardownRunnerJobID] = Job{
+		Name:   p.Name + " (teardown runner)",
+		RunsOn: p.Settings.Runner,
+		Needs:  []string{provisionRunnerJobID, p.jobID()},
+		If:     fmt.Sprintf("always() && needs.%s.result != 'skipped'", provisionRunnerJobID),
+		Steps:  steps,
+	}
+	return workflow
+}
+
+// Merge env variables, later values overriding earlier ones
+func mergeEnv(envs ...map[string]string) map[string]string {
+	merged := map[string]string{}
+	for _, env := range envs {
+		for k, v := range env {
+			merged[k] = v
+		}
+	}
+	return merged
+}
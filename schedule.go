@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Github runs scheduled workflows at most once every 5 minutes
+const minScheduleInterval = 5
+
+// A field of a cron expression
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names []string // Optional names for values, starting at min
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}},
+	{name: "day of week", min: 0, max: 6, names: []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}},
+}
+
+// Add a schedule trigger to a pipeline
+func (m *Gha) WithSchedule(
+	// Name of the pipeline
+	pipeline string,
+	// Cron expressions, or presets: "hourly", "daily(hour)", "nightly(hour)", "weekly(day, hour)", "monthly(day, hour)"
+	// Example: ["nightly", "weekly(mon, 3)"]
+	expressions []string,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	p.OnSchedule(expressions)
+	if err := p.checkSchedule(); err != nil {
+		return m, fmt.Errorf("pipeline '%s': %w", pipeline, err)
+	}
+	return m, nil
+}
+
+// Expand a human-friendly schedule preset to a cron expression.
+// Expressions which are not presets are returned unchanged.
+//   - hourly: at minute 0 of every hour
+//   - daily(hour), nightly(hour): every day at the given hour (default: midnight)
+//   - weekly(day, hour): every week on the given day (default: sunday) and hour (default: midnight)
+//   - monthly(day, hour): every month on the given day (default: 1) and hour (default: midnight)
+func expandSchedulePreset(expression string) string {
+	match := regexp.MustCompile(`^\s*(\w+)\s*(?:\((.*)\))?\s*$`).FindStringSubmatch(expression)
+	if match == nil {
+		return expression
+	}
+	var args []string
+	if match[2] != "" {
+		for _, arg := range strings.Split(match[2], ",") {
+			args = append(args, strings.TrimSpace(arg))
+		}
+	}
+	arg := func(i int, defaultValue string) string {
+		if i < len(args) && args[i] != "" {
+			return args[i]
+		}
+		return defaultValue
+	}
+	switch strings.ToLower(match[1]) {
+	case "hourly":
+		if len(args) == 0 {
+			return "0 * * * *"
+		}
+	case "daily", "nightly":
+		if len(args) <= 1 {
+			return fmt.Sprintf("0 %s * * *", arg(0, "0"))
+		}
+	case "weekly":
+		if len(args) <= 2 {
+			return fmt.Sprintf("0 %s * * %s", arg(1, "0"), strings.ToUpper(arg(0, "SUN")))
+		}
+	case "monthly":
+		if len(args) <= 2 {
+			return fmt.Sprintf("0 %s %s * *", arg(1, "0"), arg(0, "1"))
+		}
+	}
+	return expression
+}
+
+// Check that the pipeline's cron expressions are valid, and accepted by Github
+func (p *Pipeline) checkSchedule() error {
+	for _, schedule := range p.Triggers.Schedule {
+		if err := checkCron(schedule.Cron); err != nil {
+			return fmt.Errorf("invalid schedule '%s': %w", schedule.Cron, err)
+		}
+	}
+	return nil
+}
+
+// Check a POSIX cron expression, as supported by Github
+// See https://docs.github.com/en/actions/writing-workflows/choosing-when-your-workflow-runs/events-that-trigger-workflows#schedule
+func checkCron(expression string) error {
+	fields := strings.Fields(expression)
+	if len(fields) != len(cronFields) {
+		return fmt.Errorf("expected %d fields (minute hour day-of-month month day-of-week), got %d", len(cronFields), len(fields))
+	}
+	values := make([][]bool, len(fields))
+	for i, field := range fields {
+		v, err := cronFields[i].parse(field)
+		if err != nil {
+			return err
+		}
+		values[i] = v
+	}
+	// Check the interval between consecutive runs
+	minutes, hours := values[0], values[1]
+	var matched []int
+	for minute, ok := range minutes {
+		if ok {
+			matched = append(matched, minute)
+		}
+	}
+	for i := 1; i < len(matched); i++ {
+		if matched[i]-matched[i-1] < minScheduleInterval {
+			return fmt.Errorf("runs more often than every %d minutes, which Github doesn't allow", minScheduleInterval)
+		}
+	}
+	// The last run of an hour may be close to the first run of the next hour
+	if wrap := 60 - matched[len(matched)-1] + matched[0]; wrap < minScheduleInterval {
+		for hour, ok := range hours {
+			if ok && hours[(hour+1)%len(hours)] {
+				return fmt.Errorf("runs more often than every %d minutes, which Github doesn't allow", minScheduleInterval)
+			}
+		}
+	}
+	return nil
+}
+
+// Parse a cron field, and return the set of values it matches, indexed from 0
+func (f cronField) parse(field string) ([]bool, error) {
+	values := make([]bool, f.max+1)
+	for _, part := range strings.Split(field, ",") {
+		rangeExpr, stepExpr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			s, err := strconv.Atoi(stepExpr)
+			if err != nil || s <= 0 {
+				return nil, fmt.Errorf("invalid step '%s' in %s field", stepExpr, f.name)
+			}
+			step = s
+		}
+		var start, end int
+		switch {
+		case rangeExpr == "*":
+			start, end = f.min, f.max
+		case strings.Contains(rangeExpr, "-"):
+			startExpr, endExpr, _ := strings.Cut(rangeExpr, "-")
+			var err error
+			if start, err = f.value(startExpr); err != nil {
+				return nil, err
+			}
+			if end, err = f.value(endExpr); err != nil {
+				return nil, err
+			}
+			if start > end {
+				return nil, fmt.Errorf("invalid range '%s' in %s field", rangeExpr, f.name)
+			}
+		default:
+			value, err := f.value(rangeExpr)
+			if err != nil {
+				return nil, err
+			}
+			start, end = value, value
+			if hasStep {
+				end = f.max
+			}
+		}
+		for v := start; v <= end; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+// Parse a single value of a cron field, by number or by name
+func (f cronField) value(expr string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(expr, name) {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(expr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value '%s' in %s field", expr, f.name)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("value %d out of range in %s field (%d-%d)", v, f.name, f.min, f.max)
+	}
+	return v, nil
+}
@@ -0,0 +1,8 @@
+#!/bin/bash
+
+# Follow the logs of all engine containers in the background, for the rest of the job.
+# Logs are written continuously, so they survive an engine crash mid-pipeline.
+logfile="${RUNNER_TEMP:-/tmp}/dagger-engine.log"
+for container in $(docker ps --filter name="dagger-engine-*" -q); do
+    nohup docker logs -f -t "$container" >> "$logfile" 2>&1 &
+done
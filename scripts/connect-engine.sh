@@ -0,0 +1,24 @@
+#!/bin/bash --noprofile --norc -e -o pipefail
+
+GITHUB_ENV="${GITHUB_ENV:=github.env}"
+
+if [[ "$ENGINE_HOST" != tcp://* ]]; then
+    echo "::error::Unsupported engine host: '$ENGINE_HOST'. It must be a tcp:// address"
+    exit 1
+fi
+
+# Check that the engine is reachable, to fail early with a clear error
+export _EXPERIMENTAL_DAGGER_RUNNER_HOST="$ENGINE_HOST"
+for attempt in 1 2 3; do
+    if dagger core version; then
+        break
+    fi
+    if [[ $attempt -eq 3 ]]; then
+        echo "::error::Can't connect to the engine at $ENGINE_HOST"
+        exit 1
+    fi
+    sleep 5
+done
+
+# Connect the CLI to the engine, for the rest of the job
+echo "_EXPERIMENTAL_DAGGER_RUNNER_HOST=$ENGINE_HOST" >> "$GITHUB_ENV"
@@ -15,6 +15,55 @@ if [[ -n "$_EXPERIMENTAL_DAGGER_CLI_BIN" ]]; then
     export PATH=$(dirname "$_EXPERIMENTAL_DAGGER_CLI_BIN"):$PATH
 fi
 
+# Join env values which were too large to be passed in one variable
+for split in $DAGGER_SPLIT_ENV; do
+    name="${split%:*}"
+    value=
+    for ((i = 1; i <= ${split#*:}; i++)); do
+        part="${name}_DAGGER_PART_$i"
+        value+="${!part}"
+        unset "$part"
+    done
+    export "$name=$value"
+done
+unset DAGGER_SPLIT_ENV
+
+# Load secrets and variables passed in bulk, through an ephemeral env file
+if [[ -n "$DAGGER_ENV_FILE_SPEC" ]]; then
+    env_file=$(mktemp)
+    chmod 600 "$env_file"
+    while read -r kind name source; do
+        source="${source:-$name}"
+        case "$kind" in
+            secrets) json="$DAGGER_SECRETS_JSON" ;;
+            vars) json="$DAGGER_VARS_JSON" ;;
+        esac
+        value=$(jq -r --arg key "$source" '.[$key] // empty' <<< "$json")
+        printf 'export %s=%q\n' "$name" "$value" >> "$env_file"
+    done <<< "$DAGGER_ENV_FILE_SPEC"
+    source "$env_file"
+    rm -f "$env_file"
+    unset DAGGER_SECRETS_JSON DAGGER_VARS_JSON
+fi
+
+# Parse slash command arguments from the first line of the triggering comment
+if [[ -n "$SLASH_COMMAND_PREFIX" ]]; then
+    SLASH_COMMAND_ARGS="${SLASH_COMMAND_BODY#"$SLASH_COMMAND_PREFIX"}"
+    SLASH_COMMAND_ARGS="${SLASH_COMMAND_ARGS%%$'\n'*}"
+    SLASH_COMMAND_ARGS="${SLASH_COMMAND_ARGS%$'\r'}"
+    SLASH_COMMAND_ARGS="${SLASH_COMMAND_ARGS#"${SLASH_COMMAND_ARGS%%[![:space:]]*}"}"
+    export SLASH_COMMAND_ARGS
+    read -ra slash_command_args <<< "$SLASH_COMMAND_ARGS"
+    for i in "${!slash_command_args[@]}"; do
+        export "SLASH_COMMAND_ARG$((i + 1))=${slash_command_args[$i]}"
+    done
+fi
+
+# Extract the argument of the triggering label
+if [[ -n "$LABEL_PREFIX" && "$LABEL_NAME" == "$LABEL_PREFIX"* ]]; then
+    export LABEL_ARG="${LABEL_NAME#"$LABEL_PREFIX"}"
+fi
+
 GITHUB_OUTPUT="${GITHUB_OUTPUT:=github-output.txt}"
 GITHUB_STEP_SUMMARY="${GITHUB_STEP_SUMMARY:=github-summary.md}"
 export NO_COLOR="${NO_COLOR:=1}" # Disable colors in dagger logs
@@ -25,41 +74,162 @@ if [ -z "$COMMAND" ]; then
   exit 1
 fi
 
-tmp=$(mktemp -d)
-(
-    cd $tmp
+# Run without traces, for when Dagger Cloud is unreachable
+disable_cloud() {
+    echo "::warning::$1. Running without Dagger Cloud traces"
+    unset DAGGER_CLOUD_TOKEN _EXPERIMENTAL_DAGGER_CLOUD_TOKEN
+    export DAGGER_NO_NAG=1
+    DAGGER_CLOUD_OPTIONAL=
+}
+
+if [[ -n "$DAGGER_CLOUD_OPTIONAL" && -n "$DAGGER_CLOUD_TOKEN" ]]; then
+    status=$(curl -sS -o /dev/null -w '%{http_code}' --max-time 10 --retry 2 https://api.dagger.cloud/ || true)
+    if [[ -z "$status" || "$status" == 000 || "$status" -ge 500 ]]; then
+        disable_cloud "Dagger Cloud is unreachable (HTTP ${status:-000})"
+    fi
+fi
 
-    # Create named pipes (FIFOs) for stdout and stderr
-    mkfifo stdout.fifo stderr.fifo
+# Errors of the telemetry exporter, or of the connection to Dagger Cloud
+CLOUD_ERROR_PATTERN='failed to (export|upload) (spans|logs|metrics)|otlp[^ ]* exporter|(invalid|expired|unauthorized) (dagger )?cloud token|(failed to connect|connection refused|dial tcp).*(api|otel)\.dagger\.cloud'
+
+# Exchange the job's OIDC token for a short-lived PyPI API token.
+# It expires after 15 minutes, so it is minted right before the command runs
+# See https://docs.pypi.org/trusted-publishers/using-a-publisher/
+mint_pypi_token() {
+    local audience oidc_token
+    audience=$(curl -fsS "$PYPI_URL/_/oidc/audience" | jq -r .audience)
+    oidc_token=$(curl -fsS -H "Authorization: bearer $ACTIONS_ID_TOKEN_REQUEST_TOKEN" \
+        "$ACTIONS_ID_TOKEN_REQUEST_URL&audience=$audience" | jq -r .value)
+    PYPI_TOKEN=$(curl -fsS -X POST "$PYPI_URL/_/oidc/mint-token" \
+        -d "$(jq -n --arg token "$oidc_token" '{token: $token}')" | jq -r .token)
+    if [[ -z "$PYPI_TOKEN" || "$PYPI_TOKEN" == "null" ]]; then
+        echo "::error::PyPI did not mint a token. Is this workflow registered as a trusted publisher?"
+        exit 1
+    fi
+    echo "::add-mask::$PYPI_TOKEN"
+    export PYPI_TOKEN
+}
 
-    # Set up tee to capture and display stdout and stderr
-    tee stdout.txt < stdout.fifo &
-    tee stderr.txt < stderr.fifo >&2 &
-)
+tmp=$(mktemp -d)
 
-# Run the command, capturing stdout and stderr in the FIFOs
-set +e
-eval "$COMMAND" > $tmp/stdout.fifo 2> $tmp/stderr.fifo
-EXIT_CODE=$?
-set -e
-# Wait for all background jobs to finish
-wait
+# Run the command, in the pipeline's shell if any. {0} is replaced with the path of the command's script
+run_command() {
+    if [[ -z "$COMMAND_SHELL" ]]; then
+        eval "$COMMAND"
+        return
+    fi
+    local script="$tmp/command" shell="$COMMAND_SHELL"
+    case "$shell" in
+        pwsh* | powershell*) script+=".ps1" ;;
+    esac
+    printf '%s\n' "$COMMAND" > "$script"
+    if [[ "$shell" != *"{0}"* ]]; then
+        shell+=" {0}"
+    fi
+    eval "${shell//\{0\}/\"\$script\"}"
+}
+
+# Run the command, retrying on failure. Only the output of the last attempt is kept
+attempt=0
+while true; do
+    attempt=$((attempt + 1))
+    if [[ -n "$PYPI_URL" ]]; then
+        mint_pypi_token
+    fi
+    if [[ "$RUNNER_OS" == "Windows" ]]; then
+        # Named pipes are not supported on Windows: display the output after the command
+        set +e
+        run_command > $tmp/stdout.txt 2> $tmp/stderr.txt
+        EXIT_CODE=$?
+        set -e
+        cat $tmp/stdout.txt
+        cat $tmp/stderr.txt >&2
+    else
+        (
+            cd $tmp
+            rm -f stdout.fifo stderr.fifo
+
+            # Create named pipes (FIFOs) for stdout and stderr
+            mkfifo stdout.fifo stderr.fifo
+
+            # Set up tee to capture and display stdout and stderr
+            tee stdout.txt < stdout.fifo &
+            tee stderr.txt < stderr.fifo >&2 &
+        )
+
+        # Run the command, capturing stdout and stderr in the FIFOs
+        set +e
+        run_command > $tmp/stdout.fifo 2> $tmp/stderr.fifo
+        EXIT_CODE=$?
+        set -e
+        # Wait for all background jobs to finish
+        wait
+    fi
+
+    # A trace URL means tracing worked, so the failure is in the pipeline itself
+    if [[ $EXIT_CODE -ne 0 && -n "$DAGGER_CLOUD_OPTIONAL" ]] &&
+        ! grep -q '^Full trace at ' "$tmp/stderr.txt" &&
+        grep -Eqi "$CLOUD_ERROR_PATTERN" "$tmp/stderr.txt"; then
+        # Retry once without traces, without counting it as an attempt
+        disable_cloud "The pipeline failed with Dagger Cloud errors"
+        attempt=$((attempt - 1))
+        continue
+    fi
+    if [[ $EXIT_CODE -eq 0 || $attempt -gt ${DAGGER_RETRIES:-0} ]]; then
+        break
+    fi
+    echo "::warning::Attempt $attempt failed with exit code $EXIT_CODE. Retrying"
+done
 
 # Extra trace URL
 TRACE_URL=$(sed -En 's/^Full trace at (.*)/\1/p' < $tmp/stderr.txt)
 
-# Expose the outputs as GitHub Actions step outputs directly from the files
-# Multi-line outputs are handled with the '<<EOF' syntax
+# Write a step output read from stdin, with the multi-line '<<DELIMITER' syntax.
+# The delimiter is random, so the output can't end it early, and it is always on its own line.
+write_output() {
+    local delimiter value
+    delimiter="ghadelimiter_$(openssl rand -hex 16)"
+    value=$(cat)
+    printf '%s<<%s\n%s\n%s\n' "$1" "$delimiter" "$value" "$delimiter"
+}
+
+# Expose the outputs as GitHub Actions step outputs
 {
-    echo 'stdout<<EOF'
-    cat "$tmp/stdout.txt"
-    echo 'EOF'
-    echo 'stderr<<EOF'
-    cat "$tmp/stderr.txt"
-    echo 'EOF'
+    write_output stdout < "$tmp/stdout.txt"
+    write_output stderr < "$tmp/stderr.txt"
 } > "${GITHUB_OUTPUT}"
 
+# Extract named outputs from the command output
+if [[ -n "$DAGGER_OUTPUTS" ]]; then
+    while read -r name kind spec; do
+        case "$kind" in
+            json) value=$(jq -r "$spec" < "$tmp/stdout.txt" || true) ;;
+            # The key is compared as a fixed string, not a pattern
+            key) value=$(awk -v prefix="$spec=" 'index($0, prefix) == 1 { value = substr($0, length(prefix) + 1) } END { print value }' < "$tmp/stdout.txt") ;;
+        esac
+        write_output "$name" <<< "$value" >> "${GITHUB_OUTPUT}"
+    done <<< "$DAGGER_OUTPUTS"
+fi
+
+# Save the command output and logs, for the scan-logs step
+if [[ -n "$LOG_FILE" ]]; then
+    cat "$tmp/stdout.txt" "$tmp/stderr.txt" > "$LOG_FILE"
+fi
+
+# Publish the command output as a report, at the top of the job summary
+if [[ -n "$REPORT_FILE" ]]; then
+    mkdir -p "$(dirname "$REPORT_FILE")"
+    cp "$tmp/stdout.txt" "$REPORT_FILE"
+fi
+
 {
+if [[ -n "$REPORT_FILE" ]]; then
+    echo '## Report'
+    echo
+    cat "$REPORT_FILE"
+    echo
+fi
+
 cat <<'.'
 ## Dagger trace
 
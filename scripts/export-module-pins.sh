@@ -0,0 +1,39 @@
+#!/bin/bash --noprofile --norc -e -o pipefail
+
+# Export the dependency pins of the dagger module used by the pipeline, as resolved by the engine,
+# to debug module resolution differences between local runs and CI.
+# It runs before the pipeline, so verifying the pins can guard it.
+output="${RUNNER_TEMP:-/tmp}/dagger-module-pins.json"
+module="${DAGGER_MODULE:-.}"
+
+# Detect if a dev engine is available, if so: use that
+if [[ -n "$_EXPERIMENTAL_DAGGER_CLI_BIN" ]]; then
+    export PATH=$(dirname "$_EXPERIMENTAL_DAGGER_CLI_BIN"):$PATH
+fi
+
+query=$(jq -rn --arg ref "$module" \
+    '"{ moduleSource(refString: \($ref | tojson)) { dependencies { moduleName asString pin } } }"')
+dagger query -M <<< "$query" |
+    jq --arg ref "$module" \
+        '{module: $ref, dependencies: [.moduleSource.dependencies[] | {name: .moduleName, source: .asString, pin}]}' \
+        > "$output"
+cat "$output"
+
+if [[ -n "$VERIFY_MODULE_PINS" ]]; then
+    if [[ ! -f "$module/dagger.json" ]]; then
+        echo "Error: can't verify the pins of remote module $module: there is no committed dagger.json"
+        exit 1
+    fi
+    # Dependencies whose resolved pin differs from the committed pin, including unpinned dependencies
+    mismatched=$(jq -r --slurpfile committed "$module/dagger.json" \
+        '.dependencies[] as $dep
+        | (($committed[0].dependencies // []) | map(select(.name == $dep.name)) | first | .pin // "") as $pin
+        | select($pin != ($dep.pin // ""))
+        | "\($dep.name): committed \(if $pin == "" then "(unpinned)" else $pin end), resolved \($dep.pin)"' \
+        "$output")
+    if [[ -n "$mismatched" ]]; then
+        echo "Error: module dependencies resolve differently from the pins in $module/dagger.json:"
+        echo "$mismatched"
+        exit 1
+    fi
+fi
@@ -1,6 +1,35 @@
 #!/bin/bash
 
 set -o pipefail
+
+GITHUB_ENV="${GITHUB_ENV:=github.env}"
+
+# Reuse an engine already running on the runner image, if it matches the pinned version
+if [[ -z "$DAGGER_FORCE_INSTALL" ]]; then
+    if [[ -n "$_EXPERIMENTAL_DAGGER_RUNNER_HOST" ]]; then
+        echo "Using the engine configured by the runner image: $_EXPERIMENTAL_DAGGER_RUNNER_HOST"
+        echo "DAGGER_ENGINE_REUSED=1" >> "$GITHUB_ENV"
+    elif [[ "$DAGGER_VERSION" != "latest" ]] && command -v docker > /dev/null; then
+        engine=$(docker ps --filter "ancestor=registry.dagger.io/engine:$DAGGER_VERSION" --format '{{.Names}}' 2> /dev/null | head -n 1)
+        if [[ -n "$engine" ]]; then
+            echo "Using the pre-started engine $engine"
+            {
+                echo "_EXPERIMENTAL_DAGGER_RUNNER_HOST=docker-container://$engine"
+                echo "DAGGER_ENGINE_REUSED=1"
+            } >> "$GITHUB_ENV"
+        fi
+    fi
+fi
+
+# Use the dagger CLI pre-installed on the runner image, if it matches the pinned version
+if [[ -z "$DAGGER_FORCE_INSTALL" && "$DAGGER_VERSION" != "latest" ]] && command -v dagger > /dev/null; then
+    installed=$(dagger version | sed -En 's/^dagger (v[^ ]+).*/\1/p')
+    if [[ "$installed" == "$DAGGER_VERSION" ]]; then
+        echo "Using pre-installed dagger $installed: $(command -v dagger)"
+        exit 0
+    fi
+    echo "Ignoring pre-installed dagger ${installed:-(unknown version)}: pinned version is $DAGGER_VERSION"
+fi
 # Fallback to /usr/local for backwards compatability
 prefix_dir="${RUNNER_TEMP:-/usr/local}"
 
@@ -17,5 +46,20 @@ if [[ "$DAGGER_VERSION" == "latest" ]]; then
   DAGGER_VERSION=
 fi
 
-# The install.sh script creates path ${prefix_dir}/bin
+# The install.sh script doesn't support Windows: install the release archive directly
+if [[ "$RUNNER_OS" == "Windows" ]]; then
+    version="${DAGGER_VERSION:-v$(curl -fsS https://dl.dagger.io/dagger/latest_version)}"
+    mkdir -p "${prefix_dir}/bin"
+    case "$RUNNER_ARCH" in
+        ARM64) arch=arm64 ;;
+        *) arch=amd64 ;;
+    esac
+    curl -fsSL -o "${prefix_dir}/dagger.zip" \
+        "https://dl.dagger.io/dagger/releases/${version#v}/dagger_${version}_windows_${arch}.zip"
+    unzip -o "${prefix_dir}/dagger.zip" dagger.exe -d "${prefix_dir}/bin"
+    exit 0
+fi
+
+# The install.sh script detects the architecture of the runner, and creates path ${prefix_dir}/bin
+echo "Installing dagger ${DAGGER_VERSION:-latest} for $(uname -s)/$(uname -m)"
 curl -fsS https://dl.dagger.io/dagger/install.sh | BIN_DIR=${prefix_dir}/bin sh
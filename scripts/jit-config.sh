@@ -0,0 +1,25 @@
+#!/bin/bash --noprofile --norc -e -o pipefail
+
+GITHUB_OUTPUT="${GITHUB_OUTPUT:=github-output.txt}"
+
+# Generate the configuration of a just-in-time runner, which runs a single job
+# See https://docs.github.com/en/rest/actions/self-hosted-runners#create-configuration-for-a-just-in-time-runner-for-a-repository
+payload=$(jq -n \
+    --arg name "$RUNNER_NAME" \
+    --arg labels "$RUNNER_LABELS" \
+    --argjson group "$RUNNER_GROUP_ID" \
+    '{name: $name, runner_group_id: $group, labels: ($labels | split(",")), work_folder: "_work"}')
+
+response=$(curl -fsS -X POST \
+    -H "Accept: application/vnd.github+json" \
+    -H "Authorization: Bearer $RUNNER_TOKEN" \
+    -H "X-GitHub-Api-Version: 2022-11-28" \
+    "https://api.github.com/repos/$GITHUB_REPOSITORY/actions/runners/generate-jitconfig" \
+    -d "$payload")
+
+jit_config=$(jq -r .encoded_jit_config <<< "$response")
+echo "::add-mask::$jit_config"
+{
+    echo "runner-id=$(jq -r .runner.id <<< "$response")"
+    echo "jit-config=$jit_config"
+} >> "$GITHUB_OUTPUT"
@@ -0,0 +1,21 @@
+#!/bin/bash --noprofile --norc -e -o pipefail
+
+GITHUB_OUTPUT="${GITHUB_OUTPUT:=github-output.txt}"
+
+# Read the matrix file, as JSON
+case "$MATRIX_FILE" in
+    *.yml|*.yaml) matrix=$(yq -o=json '.' "$MATRIX_FILE") ;;
+    *) matrix=$(cat "$MATRIX_FILE") ;;
+esac
+
+# Select the matrix, and convert lists to a matrix object
+matrix=$(jq -c --arg key "$MATRIX_KEY" "${MATRIX_QUERY:-.}"' | if type != "array" then .
+    elif $key != "" then {($key): .}
+    else {include: .} end' <<< "$matrix")
+
+if [[ "$(jq -r type <<< "$matrix")" != "object" ]]; then
+    echo "::error file=$MATRIX_FILE::The matrix must be an object or a list, not $(jq -r type <<< "$matrix")"
+    exit 1
+fi
+echo "Matrix: $matrix"
+echo "matrix=$matrix" >> "$GITHUB_OUTPUT"
@@ -0,0 +1,23 @@
+#!/bin/bash --noprofile --norc -o pipefail
+
+# Remove the ephemeral runner, in case it never ran its job and is still registered.
+# Runners which ran their job already unregistered themselves.
+if [[ -z "$RUNNER_ID" ]]; then
+    echo "No runner to remove"
+    exit 0
+fi
+
+status=$(curl -sS -o /dev/null -w '%{http_code}' -X DELETE \
+    -H "Accept: application/vnd.github+json" \
+    -H "Authorization: Bearer $RUNNER_TOKEN" \
+    -H "X-GitHub-Api-Version: 2022-11-28" \
+    "https://api.github.com/repos/$GITHUB_REPOSITORY/actions/runners/$RUNNER_ID")
+
+case "$status" in
+    204) echo "Removed runner $RUNNER_ID" ;;
+    404) echo "Runner $RUNNER_ID already unregistered" ;;
+    *)
+        echo "::error::Failed to remove runner $RUNNER_ID: HTTP $status"
+        exit 1
+        ;;
+esac
@@ -0,0 +1,15 @@
+#!/bin/bash --noprofile --norc -e -o pipefail
+
+# Send a repository_dispatch event to another repository
+# See https://docs.github.com/en/rest/repos/repos#create-a-repository-dispatch-event
+payload=$(jq -n \
+    --arg event_type "$DISPATCH_EVENT_TYPE" \
+    --argjson client_payload "$DISPATCH_CLIENT_PAYLOAD" \
+    '{event_type: $event_type, client_payload: $client_payload}')
+
+curl -fsS -X POST \
+    -H "Accept: application/vnd.github+json" \
+    -H "Authorization: Bearer $DISPATCH_TOKEN" \
+    -H "X-GitHub-Api-Version: 2022-11-28" \
+    "https://api.github.com/repos/$DISPATCH_REPOSITORY/dispatches" \
+    -d "$payload"
@@ -0,0 +1,22 @@
+#!/bin/bash
+
+# Scan the logs saved by the exec step for patterns, and report matches as annotations
+if [[ ! -f "$LOG_FILE" ]]; then
+    echo "No logs to scan"
+    exit 0
+fi
+
+status=0
+while read -r level fail threshold pattern; do
+    [[ -z "$pattern" ]] && continue
+    count=$(grep -Ec -- "$pattern" "$LOG_FILE")
+    if [[ "$count" -lt "$threshold" ]]; then
+        continue
+    fi
+    echo "::$level title=Log scan::$count lines match '$pattern'"
+    grep -En -- "$pattern" "$LOG_FILE" | head -n 20
+    if [[ "$fail" == "true" ]]; then
+        status=1
+    fi
+done <<< "$LOG_SCANS"
+exit $status
@@ -0,0 +1,31 @@
+#!/bin/bash --noprofile --norc -e -o pipefail
+
+GITHUB_ENV="${GITHUB_ENV:=github.env}"
+
+# The install step found an engine already running on the runner image
+if [[ -n "$DAGGER_ENGINE_REUSED" ]]; then
+    echo "::warning::Reusing the engine of the runner image: its log level can't be configured"
+    exit 0
+fi
+
+case "${ENGINE_LOG_LEVEL:-info}" in
+    info) flags=() ;;
+    debug) flags=(--debug) ;;
+    trace) flags=(--debug --extra-debug) ;;
+    *)
+        echo "Error: unsupported engine log level: $ENGINE_LOG_LEVEL"
+        exit 1
+        ;;
+esac
+
+# Start an engine matching the installed CLI
+version=$(dagger version | sed -En 's/^dagger (v[^ ]+).*/\1/p')
+name="dagger-engine-$version-$GITHUB_RUN_ID"
+docker run -d --privileged \
+    --name "$name" \
+    -v /var/lib/dagger \
+    "registry.dagger.io/engine:$version" \
+    "${flags[@]}"
+
+# Connect the CLI to our engine, for the rest of the job
+echo "_EXPERIMENTAL_DAGGER_RUNNER_HOST=docker-container://$name" >> "$GITHUB_ENV"
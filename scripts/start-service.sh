@@ -0,0 +1,29 @@
+#!/bin/bash --noprofile --norc -e -o pipefail
+
+# Start a service container with docker, for runners which don't support job services (act).
+# Its env variables are inherited from the step env, so expressions are never part of the script.
+args=(run -d --name "$DAGGER_SERVICE_NAME")
+for port in $DAGGER_SERVICE_PORTS; do
+    args+=(-p "$port")
+done
+for name in $DAGGER_SERVICE_ENV; do
+    args+=(-e "$name")
+done
+# Options are split into words, like Github does for job services
+read -ra options <<< "$DAGGER_SERVICE_OPTIONS"
+docker "${args[@]}" "${options[@]}" "$DAGGER_SERVICE_IMAGE"
+
+# Wait for the service to be healthy, if it has a health check
+for _ in $(seq 60); do
+    status=$(docker inspect -f '{{if .State.Health}}{{.State.Health.Status}}{{else}}none{{end}}' "$DAGGER_SERVICE_NAME")
+    case "$status" in
+        healthy|none) exit 0 ;;
+        unhealthy)
+            echo "::error::Service $DAGGER_SERVICE_NAME is unhealthy"
+            exit 1
+            ;;
+    esac
+    sleep 2
+done
+echo "::error::Timed out waiting for service $DAGGER_SERVICE_NAME to be healthy"
+exit 1
@@ -0,0 +1,6 @@
+#!/bin/bash --noprofile --norc -o pipefail
+
+# Remove the service containers started by start-service.sh
+for name in $DAGGER_SERVICE_NAMES; do
+    docker rm -f "$name" > /dev/null || echo "::warning::Failed to remove service $name"
+done
@@ -0,0 +1,20 @@
+#!/bin/bash
+
+# Wait for the engine warm-up started in the background by the warm-engine step.
+# A hung engine fails the step after a deadline, instead of holding the job until its timeout.
+status="${RUNNER_TEMP:-/tmp}/dagger-warm-engine"
+timeout="${WAIT_ENGINE_TIMEOUT:-600}"
+while [[ ! -f "$status.exit" ]]; do
+    if [[ $SECONDS -ge $timeout ]]; then
+        echo "::error::The engine was not ready after ${timeout}s"
+        cat "$status.log"
+        for container in $(docker ps -a --filter name="dagger-engine-*" -q); do
+            echo "Logs of engine container $container:"
+            docker logs -t --tail 200 "$container" 2>&1
+        done
+        exit 1
+    fi
+    sleep 1
+done
+cat "$status.log"
+exit "$(cat "$status.exit")"
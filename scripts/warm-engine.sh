@@ -2,5 +2,22 @@
 
 # Make sure not to load any implicit module
 cd $(mktemp -d)
-# Run a simple query to "warm up" the engine
-echo '{directory{id}}' | dagger query
+
+warm() {
+    # Run a simple query to "warm up" the engine
+    echo '{directory{id}}' | dagger query
+}
+
+if [[ -n "$WARM_ENGINE_IN_BACKGROUND" ]]; then
+    # Warm up the engine while the next steps run, the wait-engine step joins it.
+    # Detach all output, so the step doesn't wait for the background process.
+    status="${RUNNER_TEMP:-/tmp}/dagger-warm-engine"
+    (
+        warm > "$status.log" 2>&1
+        echo $? > "$status.exit.tmp"
+        mv "$status.exit.tmp" "$status.exit"
+    ) < /dev/null > /dev/null 2>&1 &
+    disown
+else
+    warm
+fi
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Map a logical secret name, used by pipelines, to a differently named Github secret.
+// This allows rotating secrets, or reusing pipelines across environments, without changing them.
+func (m *Gha) WithSecretMapping(
+	// Secret name used by pipelines, as listed in their secrets
+	// Example: "API_KEY"
+	name string,
+	// Name of the Github secret to inject under that name
+	// Example: "PROD_API_KEY_2024"
+	secret string,
+	// Only map the secret for this pipeline. By default it is mapped for all pipelines, including future ones
+	// +optional
+	pipeline string,
+) (*Gha, error) {
+	mapping := name + "=" + secret
+	if pipeline != "" {
+		p := m.pipeline(pipeline)
+		if p == nil {
+			return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+		}
+		// Don't modify the mappings shared with other pipelines
+		p.Settings.SecretMappings = append(slices.Clone(p.Settings.SecretMappings), mapping)
+		return m, nil
+	}
+	m.Settings.SecretMappings = append(m.Settings.SecretMappings, mapping)
+	for _, p := range m.Pipelines {
+		p.Settings.SecretMappings = append(slices.Clone(p.Settings.SecretMappings), mapping)
+	}
+	return m, nil
+}
+
+// Return the name of the Github secret injected as the given secret.
+// Later mappings override earlier ones. Unmapped secrets follow the naming convention, if any.
+func (p *Pipeline) githubSecret(name string) string {
+	secret := p.Settings.SecretPrefix + name + p.Settings.SecretSuffix
+	for _, mapping := range p.Settings.SecretMappings {
+		if from, to, _ := strings.Cut(mapping, "="); from == name {
+			secret = to
+		}
+	}
+	return secret
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// A service container running alongside a pipeline's job
+type JobServiceSettings struct {
+	Name    string
+	Image   string
+	Ports   []string
+	Env     []string
+	Options string
+}
+
+// Run a service container alongside a pipeline's job, for example a database.
+// The service is reachable from the runner on its published ports.
+func (m *Gha) WithService(
+	// Name of the pipeline
+	pipeline string,
+	// Name of the service
+	// Example: "postgres"
+	name string,
+	// Image of the service
+	// Example: "postgres:16"
+	image string,
+	// Ports to publish on the runner, in the form HOST:CONTAINER
+	// Example: ["5432:5432"]
+	// +optional
+	ports []string,
+	// Env variables of the service, in the form KEY=VALUE
+	// Example: ["POSTGRES_PASSWORD=postgres"]
+	// +optional
+	env []string,
+	// Additional docker options, for example a health check
+	// Example: "--health-cmd pg_isready --health-interval 10s --health-timeout 5s --health-retries 5"
+	// +optional
+	options string,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	if !regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_-]*$`).MatchString(name) {
+		return m, fmt.Errorf("invalid service name: '%s'", name)
+	}
+	if slices.ContainsFunc(p.Services, func(s JobServiceSettings) bool { return s.Name == name }) {
+		return m, fmt.Errorf("pipeline '%s' already has a service '%s'", pipeline, name)
+	}
+	if _, err := parseEnv(env); err != nil {
+		return m, fmt.Errorf("service '%s': %w", name, err)
+	}
+	p.Services = append(p.Services, JobServiceSettings{
+		Name:    name,
+		Image:   image,
+		Ports:   ports,
+		Env:     env,
+		Options: options,
+	})
+	return m, nil
+}
+
+// Return the service containers of the pipeline's job, if any
+func (p *Pipeline) jobServices() map[string]JobService {
+	// act doesn't support job services: they are started by steps instead
+	if len(p.Services) == 0 || p.Settings.ActCompatible {
+		return nil
+	}
+	services := make(map[string]JobService, len(p.Services))
+	for _, settings := range p.Services {
+		service := JobService{
+			Image:   settings.Image,
+			Ports:   settings.Ports,
+			Options: settings.Options,
+		}
+		if env, _ := parseEnv(settings.Env); len(env) > 0 {
+			service.Env = env
+		}
+		services[settings.Name] = service
+	}
+	return services
+}
+
+// Return the steps starting the service containers with docker, for act
+func (p *Pipeline) startServicesSteps() []JobStep {
+	if !p.Settings.ActCompatible {
+		return nil
+	}
+	var steps []JobStep
+	for _, settings := range p.Services {
+		// The service env is passed through the step env
+		serviceEnv, _ := parseEnv(settings.Env)
+		step := p.bashStep("start-service", mergeEnv(serviceEnv, map[string]string{
+			"DAGGER_SERVICE_NAME":    settings.Name,
+			"DAGGER_SERVICE_IMAGE":   settings.Image,
+			"DAGGER_SERVICE_PORTS":   strings.Join(settings.Ports, " "),
+			"DAGGER_SERVICE_OPTIONS": settings.Options,
+			"DAGGER_SERVICE_ENV":     strings.Join(sortedKeys(serviceEnv), " "),
+		}))
+		step.ID = "start-service-" + settings.Name
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+// Return the step removing the service containers started for act, if any
+func (p *Pipeline) stopServicesSteps() []JobStep {
+	if !p.Settings.ActCompatible || len(p.Services) == 0 {
+		return nil
+	}
+	var names []string
+	for _, settings := range p.Services {
+		names = append(names, settings.Name)
+	}
+	step := p.bashStep("stop-services", map[string]string{
+		"DAGGER_SERVICE_NAMES": strings.Join(names, " "),
+	})
+	step.If = "always()"
+	return []JobStep{step}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+)
+
+// A condition attached to a step of a pipeline's job
+type StepCondition struct {
+	Step      string
+	Condition string
+}
+
+// Only run a step of a pipeline's job when a condition is met.
+// Multiple conditions on the same step are combined with a logical AND.
+func (m *Gha) WithStepCondition(
+	// Name of the pipeline
+	pipeline string,
+	// ID of the step. Generated steps have the IDs "checkout", "install-dagger", "start-engine",
+	// "warm-engine", "wait-engine", "exec", "stop-engine". Steps added with WithStep or WithRawStep can be targeted by their ID
+	step string,
+	// Github Actions expression, evaluated as the step's 'if:' condition
+	// Example: "runner.environment == 'self-hosted'"
+	condition string,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	if !p.hasStep(step) {
+		return m, fmt.Errorf("pipeline '%s' has no step with ID '%s'", pipeline, step)
+	}
+	p.StepConditions = append(p.StepConditions, StepCondition{
+		Step:      step,
+		Condition: unwrapExpression(condition),
+	})
+	return m, nil
+}
+
+// A timeout attached to a step of a pipeline's job
+type StepTimeout struct {
+	Step    string
+	Minutes int
+}
+
+// Fail a step of a pipeline's job if it runs longer than a timeout.
+// For example, fail a hung engine warm-up early rather than consuming the whole job timeout.
+func (m *Gha) WithStepTimeout(
+	// Name of the pipeline
+	pipeline string,
+	// ID of the step. See WithStepCondition for the IDs of generated steps
+	// Example: "wait-engine"
+	step string,
+	// Timeout in minutes
+	minutes int,
+) (*Gha, error) {
+	p := m.pipeline(pipeline)
+	if p == nil {
+		return m, fmt.Errorf("no such pipeline: '%s'", pipeline)
+	}
+	if minutes <= 0 {
+		return m, fmt.Errorf("invalid timeout for step '%s': %d minutes", step, minutes)
+	}
+	if !p.hasStep(step) {
+		return m, fmt.Errorf("pipeline '%s' has no step with ID '%s'", pipeline, step)
+	}
+	p.StepTimeouts = append(p.StepTimeouts, StepTimeout{Step: step, Minutes: minutes})
+	return m, nil
+}
+
+// Check if the pipeline's job has a step with the given ID
+func (p *Pipeline) hasStep(id string) bool {
+	for _, step := range p.asWorkflow().Jobs[p.jobID()].Steps {
+		if step.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Set the timeouts attached to each step. Later timeouts override earlier ones
+func (p *Pipeline) applyStepTimeouts(steps []JobStep) []JobStep {
+	for i, step := range steps {
+		for _, t := range p.StepTimeouts {
+			if t.Step == step.ID && step.ID != "" {
+				steps[i].TimeoutMinutes = t.Minutes
+			}
+		}
+	}
+	return steps
+}
+
+// Combine the conditions attached to each step with its own condition
+func (p *Pipeline) applyStepConditions(steps []JobStep) []JobStep {
+	for i, step := range steps {
+		var conditions []string
+		if step.If != "" {
+			conditions = append(conditions, unwrapExpression(step.If))
+		}
+		for _, c := range p.StepConditions {
+			if c.Step == step.ID && step.ID != "" {
+				conditions = append(conditions, c.Condition)
+			}
+		}
+		steps[i].If = andConditions(conditions...)
+	}
+	return steps
+}
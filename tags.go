@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shykes/gha/internal/dagger"
+)
+
+// Declare the tag patterns protected in the repository, for example "v*".
+// Pipelines triggered by tags which these patterns don't cover are reported by Validate,
+// since anyone with write access could push such a tag and trigger a release.
+func (m *Gha) WithProtectedTags(
+	// Protected tag patterns
+	// Example: ["v*"]
+	patterns []string,
+) *Gha {
+	m.Settings.ProtectedTags = append(m.Settings.ProtectedTags, patterns...)
+	for _, p := range m.Pipelines {
+		// Don't modify the patterns shared with other pipelines
+		p.Settings.ProtectedTags = append(slices.Clone(p.Settings.ProtectedTags), patterns...)
+	}
+	return m
+}
+
+// Warn if the pipeline can be triggered by tags which are not protected
+func (p *Pipeline) checkProtectedTags() {
+	push := p.Triggers.Push
+	if len(p.Settings.ProtectedTags) == 0 || push == nil {
+		return
+	}
+	if len(push.Tags) == 0 {
+		// Without any filter, a push event is triggered by all tags
+		if len(push.Branches) == 0 && len(push.BranchesIgnore) == 0 && !tagProtected(p.Settings.ProtectedTags, "**") {
+			fmt.Fprintf(os.Stderr,
+				"warning: pipeline '%s' is triggered by all tags, including unprotected ones\n",
+				p.Name)
+		}
+		return
+	}
+	for _, tag := range push.Tags {
+		if strings.HasPrefix(tag, "!") {
+			continue
+		}
+		if !tagProtected(p.Settings.ProtectedTags, tag) {
+			fmt.Fprintf(os.Stderr,
+				"warning: pipeline '%s' is triggered by tags matching '%s', which are not all protected\n",
+				p.Name, tag)
+		}
+	}
+}
+
+// Return true if all tags matching the filter pattern are matched by a protected pattern.
+// This is best effort: a pattern is only covered by a protected pattern which matches it literally.
+func tagProtected(protected []string, pattern string) bool {
+	for _, p := range protected {
+		if p == "**" || p == pattern {
+			return true
+		}
+		if strings.Contains(pattern, "**") {
+			continue
+		}
+		if ok, _ := path.Match(p, pattern); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// A repository ruleset, in the format of the Github API
+// See https://docs.github.com/en/rest/repos/rules#create-a-repository-ruleset
+type TagRuleset struct {
+	Name         string              `json:"name"`
+	Target       string              `json:"target"`
+	Enforcement  string              `json:"enforcement"`
+	Conditions   map[string]any      `json:"conditions"`
+	Rules        []map[string]string `json:"rules"`
+	BypassActors []map[string]any    `json:"bypass_actors"`
+}
+
+// Protect the declared tag patterns with a repository ruleset, so only admins can create,
+// update or delete matching tags.
+// Running it again updates the ruleset with the same name.
+func (m *Gha) ConfigureTagProtection(
+	ctx context.Context,
+	// Github token with administration permission on the repository
+	token *dagger.Secret,
+	// Repository to configure
+	// Example: "my-org/my-repo"
+	repository string,
+	// Name of the ruleset
+	// +optional
+	// +default="Protected tags"
+	name string,
+) (string, error) {
+	if len(m.Settings.ProtectedTags) == 0 {
+		return "", fmt.Errorf("no protected tags: declare them with withProtectedTags")
+	}
+	var include []string
+	for _, pattern := range m.Settings.ProtectedTags {
+		include = append(include, "refs/tags/"+pattern)
+	}
+	ruleset := TagRuleset{
+		Name:        name,
+		Target:      "tag",
+		Enforcement: "active",
+		Conditions: map[string]any{
+			"ref_name": map[string][]string{
+				"include": include,
+				"exclude": {},
+			},
+		},
+		Rules: []map[string]string{
+			{"type": "creation"},
+			{"type": "update"},
+			{"type": "deletion"},
+		},
+		// Repository admins
+		BypassActors: []map[string]any{
+			{"actor_id": 5, "actor_type": "RepositoryRole", "bypass_mode": "always"},
+		},
+	}
+	payload, err := json.MarshalIndent(ruleset, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	url := fmt.Sprintf("https://api.github.com/repos/%s/rulesets", repository)
+	// Update the ruleset with the same name if it exists, so running again doesn't duplicate it
+	return githubAPIContainer(token).
+		// The rulesets can be changed elsewhere: don't cache them
+		WithEnvVariable("CACHE_BUSTER", time.Now().String()).
+		WithNewFile("/payload.json", string(payload)).
+		WithExec([]string{"sh", "-c",
+			`id=$(curl -fsS ` + githubAPIHeaders + ` "$0?per_page=100" | jq -r --arg name "$1" '.[] | select(.target == "tag" and .name == $name) | .id' | head -n 1)
+if [ -n "$id" ]; then
+	curl -fsS -X PUT ` + githubAPIHeaders + ` -d @/payload.json "$0/$id"
+else
+	curl -fsS -X POST ` + githubAPIHeaders + ` -d @/payload.json "$0"
+fi`,
+			url, name,
+		}).
+		Stdout(ctx)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/shykes/gha/internal/dagger"
+)
+
+// Generate a CODENOTIFY file routing the review of generated workflows to their owners.
+// Github doesn't support subdirectories of .github/workflows, so each team's workflows
+// are sharded by filename prefix instead.
+// See https://github.com/sourcegraph/codenotify
+func (m *Gha) ownership(pipelines []*Pipeline) *dagger.Directory {
+	var lines []string
+	for _, p := range m.workflowPipelines(pipelines) {
+		if p.Workflow != "" || len(p.Owners) == 0 {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s %s", p.workflowFilename(), strings.Join(p.Owners, " ")))
+	}
+	if len(lines) == 0 {
+		return dag.Directory()
+	}
+	contents := genHeader + "\n" + commentBlock(m.Settings.Banner, "# ") + strings.Join(lines, "\n") + "\n"
+	return dag.
+		Directory().
+		WithNewFile(".github/workflows/CODENOTIFY", contents)
+}
+
+// Return the filename prefix of the team owning the pipeline, if any
+func (p *Pipeline) teamPrefix() string {
+	if p.Team == "" {
+		return ""
+	}
+	team := regexp.MustCompile(`[^a-z0-9]+`).ReplaceAllString(strings.ToLower(p.Team), "-")
+	return strings.Trim(team, "-") + "-"
+}
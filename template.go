@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Template placeholders in commands, like '{{ .PipelineName }}' or '{{ .Matrix.os }}'.
+// Github expressions like '${{ matrix.os }}' are left alone.
+// Expressions are matched too, and skipped, so adjacent placeholders are all expanded.
+var commandPlaceholder = regexp.MustCompile(`\$?\{\{\s*\.([A-Za-z]+)(?:\.([A-Za-z0-9_-]+))?\s*\}\}`)
+
+// Resolve the template placeholders of a command with the pipeline's metadata, at generation time:
+//
+//   - {{ .PipelineName }}: name of the pipeline
+//   - {{ .Module }}: module of the pipeline
+//   - {{ .Runner }}: labels of the runner, separated by commas
+//   - {{ .Tags }}: tags of the pipeline, separated by commas
+//   - {{ .Matrix.KEY }}: value of a matrix dimension in the current job
+func (p *Pipeline) expandCommand(command string) (string, error) {
+	var errs []string
+	expanded := commandPlaceholder.ReplaceAllStringFunc(command, func(match string) string {
+		if strings.HasPrefix(match, "$") {
+			return match
+		}
+		groups := commandPlaceholder.FindStringSubmatch(match)
+		value, err := p.templateValue(groups[1], groups[2])
+		if err != nil {
+			errs = append(errs, err.Error())
+			return match
+		}
+		return value
+	})
+	if len(errs) > 0 {
+		return command, fmt.Errorf("invalid command template: %s", strings.Join(errs, "; "))
+	}
+	return expanded, nil
+}
+
+func (p *Pipeline) templateValue(field, key string) (string, error) {
+	if field == "Matrix" {
+		if p.DynamicMatrix == nil && !slices.ContainsFunc(p.Matrix, func(d MatrixDimension) bool { return d.Key == key }) {
+			return "", fmt.Errorf("matrix key '%s' is not defined. See WithMatrix", key)
+		}
+		return fmt.Sprintf("${{ matrix.%s }}", key), nil
+	}
+	if key != "" {
+		return "", fmt.Errorf("'.%s' has no field '%s'", field, key)
+	}
+	switch field {
+	case "PipelineName":
+		return p.Name, nil
+	case "Module":
+		return p.Module, nil
+	case "Runner":
+		return strings.Join(p.Settings.Runner, ","), nil
+	case "Tags":
+		return strings.Join(p.Tags, ","), nil
+	}
+	return "", fmt.Errorf("unknown field '.%s'. Possible fields: .PipelineName, .Module, .Runner, .Tags, .Matrix.KEY", field)
+}
+
+// Check that the template placeholders of the pipeline's commands can be resolved
+func (p *Pipeline) checkCommandTemplates() error {
+	for _, command := range p.rawCommands() {
+		if _, err := p.expandCommand(command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/shykes/gha/internal/dagger"
+)
+
+// Maximum number of jobs generated by a Github Actions matrix
+const maxMatrixJobs = 256
+
+// Generate a workflow which validates the configuration in CI, across a matrix of jobs,
+// so validating a large number of pipelines is parallelized.
+// Each job calls Validate on a shard of the pipelines.
+func (m *Gha) ValidateWorkflow(
+	// Dagger command returning this Github Actions configuration
+	// Example: "gha"
+	command string,
+	// The Dagger module to load
+	// Example: ".github"
+	// +optional
+	module string,
+	// Number of jobs to split the pipelines into. By default, each pipeline is validated by its own job
+	// +optional
+	shards int,
+	// Name of the generated workflow
+	// +optional
+	// +default="Validate Github Actions"
+	name string,
+) (*dagger.Directory, error) {
+	var names []string
+	for _, p := range m.Pipelines {
+		if strings.Contains(p.Name, ",") {
+			return nil, fmt.Errorf("pipeline '%s' can't be validated in a shard: its name contains a comma", p.Name)
+		}
+		names = append(names, p.Name)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no pipelines to validate")
+	}
+	if shards <= 0 || shards > len(names) {
+		shards = len(names)
+	}
+	shards = min(shards, maxMatrixJobs)
+	p := &Pipeline{
+		Name: name,
+		// The shard is passed through the env, so pipeline names are never interpreted by the shell
+		Command:  command + ` validate --repo=. --only-pipelines="$PIPELINES"`,
+		Module:   module,
+		Settings: m.Settings,
+		Matrix: []MatrixDimension{
+			{Key: "pipelines", Values: shardNames(names, shards)},
+		},
+	}
+	p.Settings.Env = append(slices.Clone(p.Settings.Env), "PIPELINES=${{ matrix.pipelines }}")
+	p.onPullRequestAndPushToMain()
+	return p.Config(), nil
+}
+
+// Split names into shards of roughly equal size, each a comma-separated list
+func shardNames(names []string, shards int) []string {
+	names = slices.Clone(names)
+	slices.Sort(names)
+	var values []string
+	for i := range shards {
+		start, end := i*len(names)/shards, (i+1)*len(names)/shards
+		values = append(values, strings.Join(names[start:end], ","))
+	}
+	return values
+}
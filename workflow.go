@@ -2,21 +2,53 @@ package main
 
 import (
 	"encoding/json"
+	"slices"
+	"strings"
 
 	"github.com/shykes/gha/internal/dagger"
 	"gopkg.in/yaml.v3"
 )
 
 const (
-	genHeader = "# This file was generated. See https://daggerverse.dev/mod/github.com/shykes/gha"
+	genMessage = "This file was generated. See https://daggerverse.dev/mod/github.com/shykes/gha"
+	genHeader  = "# " + genMessage
 )
 
+// Check if the contents of a file were generated by this module
+func isGenerated(contents string) bool {
+	return strings.HasPrefix(contents, "# This file was generated.") ||
+		strings.HasPrefix(contents, "<!-- This file was generated.")
+}
+
 type Workflow struct {
 	Name        string               `json:"name,omitempty" yaml:"name,omitempty"`
-	On          WorkflowTriggers     `json:"on" yaml:"on"`
+	RunName     string               `json:"run-name,omitempty" yaml:"run-name,omitempty"`
+	On          WorkflowOn           `json:"on" yaml:"on"`
 	Concurrency *WorkflowConcurrency `json:"concurrency,omitempty" yaml:"concurrency,omitempty"`
+	Permissions *JobPermissions      `json:"permissions,omitempty" yaml:"permissions,omitempty"`
 	Jobs        map[string]Job       `json:"jobs" yaml:"jobs"`
-	Env         map[string]string    `json:"env,omitempty" yaml:"env,omitempty"`
+	Env         Env                  `json:"env,omitempty" yaml:"env,omitempty"`
+	Defaults    *Defaults            `json:"defaults,omitempty" yaml:"defaults,omitempty"`
+}
+
+// Env variables of a workflow, job or step.
+// Values often contain expressions like ${{ secrets.X }}, or special YAML characters,
+// so they are always quoted explicitly.
+type Env map[string]string
+
+func (env Env) MarshalYAML() (any, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, key := range sortedKeys(env) {
+		value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: env[key], Style: yaml.DoubleQuotedStyle}
+		if strings.Contains(env[key], "\n") {
+			value.Style = yaml.LiteralStyle
+		}
+		node.Content = append(node.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+			value,
+		)
+	}
+	return node, nil
 }
 
 // Generate an overlay config directory for this workflow
@@ -25,6 +57,8 @@ func (w Workflow) Config(
 	filename string,
 	// Encode the workflow as JSON, which is valid YAML
 	asJson bool,
+	// Banner to insert as a comment block after the header
+	banner string,
 ) *dagger.Directory {
 	var (
 		contents []byte
@@ -40,81 +74,301 @@ func (w Workflow) Config(
 	}
 	return dag.
 		Directory().
-		WithNewFile(".github/workflows/"+filename, genHeader+"\n"+string(contents))
+		WithNewFile(".github/workflows/"+filename, genHeader+"\n"+commentBlock(banner, "# ")+string(contents))
+}
+
+// Generate an overlay directory with a plain JSON copy of this workflow, for tooling.
+// JSON has no comments, so there is no header.
+func (w Workflow) JsonConfig(
+	// Path of the JSON file, relative to the repository root
+	filename string,
+) *dagger.Directory {
+	contents, err := json.MarshalIndent(w, "", " ")
+	if err != nil {
+		panic(err)
+	}
+	return dag.
+		Directory().
+		WithNewFile(filename, string(contents))
+}
+
+// Format a text as a comment block, each line starting with the given prefix
+func commentBlock(text, prefix string) string {
+	if text == "" {
+		return ""
+	}
+	var block strings.Builder
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		block.WriteString(strings.TrimRight(prefix+line, " ") + "\n")
+	}
+	return block.String()
 }
 
 type WorkflowConcurrency struct {
-	Group            string `json:"group,omitempty" yaml:"group,omitempty"`
-	CancelInProgress bool   `json:"cancel-in-progress,omitempty" yaml:"cancel-in-progress,omitempty"`
+	Group string `json:"group,omitempty" yaml:"group,omitempty"`
+	// A boolean, or an expression
+	CancelInProgress any `json:"cancel-in-progress,omitempty" yaml:"cancel-in-progress,omitempty"`
+}
+
+// The complete set of workflow triggers, as serialized to the workflow file.
+// It is never stored, so it can use maps.
+type WorkflowOn struct {
+	Push              *PushEvent               `json:"push,omitempty" yaml:"push,omitempty"`
+	PullRequest       *PullRequestEvent        `json:"pull_request,omitempty" yaml:"pull_request,omitempty"`
+	PullRequestTarget *PullRequestEvent        `json:"pull_request_target,omitempty" yaml:"pull_request_target,omitempty"`
+	Schedule          []ScheduledEvent         `json:"schedule,omitempty" yaml:"schedule,omitempty"`
+	WorkflowDispatch  *WorkflowDispatchTrigger `json:"workflow_dispatch,omitempty" yaml:"workflow_dispatch,omitempty"`
+	IssueComment      *IssueCommentEvent       `json:"issue_comment,omitempty" yaml:"issue_comment,omitempty"`
+	Issues            *IssuesEvent             `json:"issues,omitempty" yaml:"issues,omitempty"`
+	WorkflowRun       *WorkflowRunEvent        `json:"workflow_run,omitempty" yaml:"workflow_run,omitempty"`
+	PageBuild         *PageBuildEvent          `json:"page_build,omitempty" yaml:"page_build,omitempty"`
+	WorkflowCall      *WorkflowCallEvent       `json:"workflow_call,omitempty" yaml:"workflow_call,omitempty"`
+	// Events which are not modeled yet, see WithRawTrigger
+	Raw map[string]any `json:"-" yaml:",inline"`
 }
 
+// Pipeline triggers, as stored in the pipeline state
 type WorkflowTriggers struct {
-	Push             *PushEvent             `json:"push,omitempty" yaml:"push,omitempty"`
-	PullRequest      *PullRequestEvent      `json:"pull_request,omitempty" yaml:"pull_request,omitempty"`
-	Schedule         []ScheduledEvent       `json:"schedule,omitempty" yaml:"schedule,omitempty"`
-	WorkflowDispatch *WorkflowDispatchEvent `json:"workflow_dispatch,omitempty" yaml:"workflow_dispatch,omitempty"`
-	IssueComment     *IssueCommentEvent     `json:"issue_comment,omitempty" yaml:"issue_comment,omitempty"`
+	Push              *PushEvent             `json:"push,omitempty" yaml:"push,omitempty"`
+	PullRequest       *PullRequestEvent      `json:"pull_request,omitempty" yaml:"pull_request,omitempty"`
+	PullRequestTarget *PullRequestEvent      `json:"pull_request_target,omitempty" yaml:"pull_request_target,omitempty"`
+	Schedule          []ScheduledEvent       `json:"schedule,omitempty" yaml:"schedule,omitempty"`
+	WorkflowDispatch  *WorkflowDispatchEvent `json:"workflow_dispatch,omitempty" yaml:"workflow_dispatch,omitempty"`
+	IssueComment      *IssueCommentEvent     `json:"issue_comment,omitempty" yaml:"issue_comment,omitempty"`
+	Issues            *IssuesEvent           `json:"issues,omitempty" yaml:"issues,omitempty"`
+	WorkflowRun       *WorkflowRunEvent      `json:"workflow_run,omitempty" yaml:"workflow_run,omitempty"`
+	PageBuild         *PageBuildEvent        `json:"page_build,omitempty" yaml:"page_build,omitempty"`
+}
+
+// Merge another set of triggers into this one, combining their filters
+func (t *WorkflowTriggers) merge(other WorkflowTriggers) {
+	if other.Push != nil {
+		if t.Push == nil {
+			t.Push = &PushEvent{}
+		}
+		t.Push.Branches = appendUnique(t.Push.Branches, other.Push.Branches...)
+		t.Push.Tags = appendUnique(t.Push.Tags, other.Push.Tags...)
+		t.Push.Paths = appendUnique(t.Push.Paths, other.Push.Paths...)
+		t.Push.BranchesIgnore = appendUnique(t.Push.BranchesIgnore, other.Push.BranchesIgnore...)
+		t.Push.TagsIgnore = appendUnique(t.Push.TagsIgnore, other.Push.TagsIgnore...)
+		t.Push.PathsIgnore = appendUnique(t.Push.PathsIgnore, other.Push.PathsIgnore...)
+	}
+	t.PullRequest = mergePullRequestEvent(t.PullRequest, other.PullRequest)
+	t.PullRequestTarget = mergePullRequestEvent(t.PullRequestTarget, other.PullRequestTarget)
+	for _, schedule := range other.Schedule {
+		if !slices.Contains(t.Schedule, schedule) {
+			t.Schedule = append(t.Schedule, schedule)
+		}
+	}
+	if other.WorkflowDispatch != nil && t.WorkflowDispatch == nil {
+		t.WorkflowDispatch = other.WorkflowDispatch
+	}
+	if other.IssueComment != nil {
+		if t.IssueComment == nil {
+			t.IssueComment = &IssueCommentEvent{}
+		}
+		t.IssueComment.Types = appendUnique(t.IssueComment.Types, other.IssueComment.Types...)
+	}
+	if other.Issues != nil {
+		if t.Issues == nil {
+			t.Issues = &IssuesEvent{}
+		}
+		t.Issues.Types = appendUnique(t.Issues.Types, other.Issues.Types...)
+	}
+	if other.WorkflowRun != nil {
+		if t.WorkflowRun == nil {
+			t.WorkflowRun = &WorkflowRunEvent{}
+		}
+		t.WorkflowRun.Workflows = appendUnique(t.WorkflowRun.Workflows, other.WorkflowRun.Workflows...)
+		t.WorkflowRun.Types = appendUnique(t.WorkflowRun.Types, other.WorkflowRun.Types...)
+		t.WorkflowRun.Branches = appendUnique(t.WorkflowRun.Branches, other.WorkflowRun.Branches...)
+	}
+	if other.PageBuild != nil && t.PageBuild == nil {
+		t.PageBuild = other.PageBuild
+	}
+}
+
+func mergePullRequestEvent(event, other *PullRequestEvent) *PullRequestEvent {
+	if other == nil {
+		return event
+	}
+	if event == nil {
+		event = &PullRequestEvent{}
+	}
+	event.Types = appendUnique(event.Types, other.Types...)
+	event.Branches = appendUnique(event.Branches, other.Branches...)
+	event.Paths = appendUnique(event.Paths, other.Paths...)
+	event.BranchesIgnore = appendUnique(event.BranchesIgnore, other.BranchesIgnore...)
+	event.PathsIgnore = appendUnique(event.PathsIgnore, other.PathsIgnore...)
+	return event
 }
 
 type PushEvent struct {
-	Branches []string `json:"branches,omitempty" yaml:"branches,omitempty"`
-	Tags     []string `json:"tags,omitempty" yaml:"tags,omitempty"`
-	Paths    []string `json:"paths,omitempty" yaml:"paths,omitempty"`
+	Branches       []string `json:"branches,omitempty" yaml:"branches,omitempty"`
+	Tags           []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Paths          []string `json:"paths,omitempty" yaml:"paths,omitempty"`
+	BranchesIgnore []string `json:"branches-ignore,omitempty" yaml:"branches-ignore,omitempty"`
+	TagsIgnore     []string `json:"tags-ignore,omitempty" yaml:"tags-ignore,omitempty"`
+	PathsIgnore    []string `json:"paths-ignore,omitempty" yaml:"paths-ignore,omitempty"`
 }
 
 type PullRequestEvent struct {
-	Types    []string `json:"types,omitempty" yaml:"types,omitempty"`
-	Branches []string `json:"branches,omitempty" yaml:"branches,omitempty"`
-	Paths    []string `json:"paths,omitempty" yaml:"paths,omitempty"`
+	Types          []string `json:"types,omitempty" yaml:"types,omitempty"`
+	Branches       []string `json:"branches,omitempty" yaml:"branches,omitempty"`
+	Paths          []string `json:"paths,omitempty" yaml:"paths,omitempty"`
+	BranchesIgnore []string `json:"branches-ignore,omitempty" yaml:"branches-ignore,omitempty"`
+	PathsIgnore    []string `json:"paths-ignore,omitempty" yaml:"paths-ignore,omitempty"`
 }
 
 type ScheduledEvent struct {
 	Cron string `json:"cron" yaml:"cron"`
 }
 
-type WorkflowDispatchEvent struct {
-	// FIXME: The Dagger API can't serialize maps
-	// Inputs map[string]DispatchInput `json:"inputs,omitempty" yaml:"inputs,omitempty"`
+type WorkflowDispatchEvent struct{}
+
+// Trigger for manually dispatched workflows, as serialized to the workflow file.
+// The Dagger API can't serialize maps, so inputs are stored separately in the pipeline.
+type WorkflowDispatchTrigger struct {
+	Inputs map[string]DispatchInput `json:"inputs,omitempty" yaml:"inputs,omitempty"`
 }
 
 type IssueCommentEvent struct {
 	Types []string `json:"types,omitempty" yaml:"types,omitempty"`
 }
 
-type DispatchInput struct {
+type IssuesEvent struct {
+	Types []string `json:"types,omitempty" yaml:"types,omitempty"`
+}
+
+type WorkflowRunEvent struct {
+	Workflows []string `json:"workflows,omitempty" yaml:"workflows,omitempty"`
+	Types     []string `json:"types,omitempty" yaml:"types,omitempty"`
+	Branches  []string `json:"branches,omitempty" yaml:"branches,omitempty"`
+}
+
+type PageBuildEvent struct{}
+
+// Trigger for reusable workflows
+type WorkflowCallEvent struct {
+	Inputs  map[string]WorkflowCallInput  `json:"inputs,omitempty" yaml:"inputs,omitempty"`
+	Secrets map[string]WorkflowCallSecret `json:"secrets,omitempty" yaml:"secrets,omitempty"`
+	Outputs map[string]WorkflowCallOutput `json:"outputs,omitempty" yaml:"outputs,omitempty"`
+}
+
+type WorkflowCallInput struct {
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Required    bool   `json:"required,omitempty" yaml:"required,omitempty"`
+	Default     any    `json:"default,omitempty" yaml:"default,omitempty"`
+	Type        string `json:"type" yaml:"type"`
+}
+
+type WorkflowCallSecret struct {
 	Description string `json:"description,omitempty" yaml:"description,omitempty"`
 	Required    bool   `json:"required,omitempty" yaml:"required,omitempty"`
-	Default     string `json:"default,omitempty" yaml:"default,omitempty"`
+}
+
+type WorkflowCallOutput struct {
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	Value       string `json:"value" yaml:"value"`
+}
+
+type DispatchInput struct {
+	Description string   `json:"description,omitempty" yaml:"description,omitempty"`
+	Required    bool     `json:"required,omitempty" yaml:"required,omitempty"`
+	Default     any      `json:"default,omitempty" yaml:"default,omitempty"`
+	Type        string   `json:"type,omitempty" yaml:"type,omitempty"`
+	Options     []string `json:"options,omitempty" yaml:"options,omitempty"`
 }
 
 type Job struct {
-	RunsOn         []string          `json:"runs-on" yaml:"runs-on"`
-	Permissions    *JobPermissions   `json:"permissions,omitempty" yaml:"permissions,omitempty"`
-	Name           string            `json:"name" yaml:"name"`
-	Needs          []string          `json:"needs,omitempty" yaml:"needs,omitempty"`
-	Steps          []JobStep         `json:"steps" yaml:"steps"`
-	Env            map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
-	Strategy       *Strategy         `json:"strategy,omitempty" yaml:"strategy,omitempty"`
-	TimeoutMinutes int               `json:"timeout-minutes,omitempty" yaml:"timeout-minutes,omitempty"`
-	Outputs        map[string]string `json:"outputs,omitempty" yaml:"outputs,omitempty"`
+	RunsOn         []string              `json:"runs-on,omitempty" yaml:"runs-on,omitempty"`
+	Permissions    *JobPermissions       `json:"permissions,omitempty" yaml:"permissions,omitempty"`
+	Name           string                `json:"name" yaml:"name"`
+	If             string                `json:"if,omitempty" yaml:"if,omitempty"`
+	Needs          []string              `json:"needs,omitempty" yaml:"needs,omitempty"`
+	Environment    *JobEnvironment       `json:"environment,omitempty" yaml:"environment,omitempty"`
+	Container      *JobContainer         `json:"container,omitempty" yaml:"container,omitempty"`
+	Services       map[string]JobService `json:"services,omitempty" yaml:"services,omitempty"`
+	Defaults       *Defaults             `json:"defaults,omitempty" yaml:"defaults,omitempty"`
+	Steps          []JobStep             `json:"steps,omitempty" yaml:"steps,omitempty"`
+	Env            Env                   `json:"env,omitempty" yaml:"env,omitempty"`
+	Strategy       *Strategy             `json:"strategy,omitempty" yaml:"strategy,omitempty"`
+	TimeoutMinutes int                   `json:"timeout-minutes,omitempty" yaml:"timeout-minutes,omitempty"`
+	Outputs        map[string]string     `json:"outputs,omitempty" yaml:"outputs,omitempty"`
+	// A boolean, or an expression
+	ContinueOnError any `json:"continue-on-error,omitempty" yaml:"continue-on-error,omitempty"`
+	// Reusable workflow called by the job, instead of running steps
+	Uses string `json:"uses,omitempty" yaml:"uses,omitempty"`
+	// Inputs of the reusable workflow: strings, booleans or numbers
+	With map[string]any `json:"with,omitempty" yaml:"with,omitempty"`
+	// Secrets passed to the reusable workflow: a map, or "inherit"
+	Secrets any `json:"secrets,omitempty" yaml:"secrets,omitempty"`
+}
+
+// A Github deployment environment
+// See https://docs.github.com/en/actions/managing-workflow-runs-and-deployments/managing-deployments/managing-environments-for-deployment
+type JobEnvironment struct {
+	Name string `json:"name" yaml:"name"`
+	URL  string `json:"url,omitempty" yaml:"url,omitempty"`
+}
+
+// A container to run a job in
+// See https://docs.github.com/en/actions/writing-workflows/choosing-where-your-workflow-runs/running-jobs-in-a-container
+type JobContainer struct {
+	Image       string                   `json:"image" yaml:"image"`
+	Credentials *JobContainerCredentials `json:"credentials,omitempty" yaml:"credentials,omitempty"`
+	Env         Env                      `json:"env,omitempty" yaml:"env,omitempty"`
+	Volumes     []string                 `json:"volumes,omitempty" yaml:"volumes,omitempty"`
+	Options     string                   `json:"options,omitempty" yaml:"options,omitempty"`
+}
+
+// Default settings of a workflow or job
+type Defaults struct {
+	Run *RunDefaults `json:"run,omitempty" yaml:"run,omitempty"`
+}
+
+// Default settings of 'run' steps
+type RunDefaults struct {
+	Shell            string `json:"shell,omitempty" yaml:"shell,omitempty"`
+	WorkingDirectory string `json:"working-directory,omitempty" yaml:"working-directory,omitempty"`
+}
+
+// A service container running alongside a job
+type JobService struct {
+	Image   string   `json:"image" yaml:"image"`
+	Ports   []string `json:"ports,omitempty" yaml:"ports,omitempty"`
+	Env     Env      `json:"env,omitempty" yaml:"env,omitempty"`
+	Options string   `json:"options,omitempty" yaml:"options,omitempty"`
+}
+
+type JobContainerCredentials struct {
+	Username string `json:"username,omitempty" yaml:"username,omitempty"`
+	Password string `json:"password,omitempty" yaml:"password,omitempty"`
 }
 
 type JobStep struct {
-	Name           string            `json:"name,omitempty" yaml:"name,omitempty"`
-	ID             string            `json:"id,omitempty" yaml:"id,omitempty"`
-	Uses           string            `json:"uses,omitempty" yaml:"uses,omitempty"`
-	Run            string            `json:"run,omitempty" yaml:"run,omitempty"`
-	With           map[string]string `json:"with,omitempty" yaml:"with,omitempty"`
-	Env            map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
-	TimeoutMinutes int               `json:"timeout-minutes,omitempty" yaml:"timeout-minutes,omitempty"`
-	Shell          string            `json:"shell,omitempty" yaml:"shell,omitempty"`
+	Name             string            `json:"name,omitempty" yaml:"name,omitempty"`
+	ID               string            `json:"id,omitempty" yaml:"id,omitempty"`
+	If               string            `json:"if,omitempty" yaml:"if,omitempty"`
+	Uses             string            `json:"uses,omitempty" yaml:"uses,omitempty"`
+	Run              string            `json:"run,omitempty" yaml:"run,omitempty"`
+	With             map[string]string `json:"with,omitempty" yaml:"with,omitempty"`
+	Env              Env               `json:"env,omitempty" yaml:"env,omitempty"`
+	TimeoutMinutes   int               `json:"timeout-minutes,omitempty" yaml:"timeout-minutes,omitempty"`
+	Shell            string            `json:"shell,omitempty" yaml:"shell,omitempty"`
+	ContinueOnError  bool              `json:"continue-on-error,omitempty" yaml:"continue-on-error,omitempty"`
+	WorkingDirectory string            `json:"working-directory,omitempty" yaml:"working-directory,omitempty"`
 	// Other step-specific fields can be added here...
+	// Fields which are not modeled yet, see WithRawStep
+	Raw map[string]any `json:"-" yaml:",inline"`
 }
 
 type Strategy struct {
-	Matrix      map[string][]string `json:"matrix,omitempty" yaml:"matrix,omitempty"`
-	MaxParallel int                 `json:"max-parallel,omitempty" yaml:"max-parallel,omitempty"`
-	FailFast    bool                `json:"fail-fast,omitempty" yaml:"fail-fast,omitempty"`
+	// Values of each matrix key, and the special keys "include" and "exclude".
+	// Or an expression, for matrices computed when the workflow runs
+	Matrix      any  `json:"matrix,omitempty" yaml:"matrix,omitempty"`
+	MaxParallel int  `json:"max-parallel,omitempty" yaml:"max-parallel,omitempty"`
+	FailFast    bool `json:"fail-fast,omitempty" yaml:"fail-fast,omitempty"`
 }
 
 // PermissionLevel represents the possible levels of permissions in a job.
@@ -131,6 +385,7 @@ type JobPermissions struct {
 	Contents           PermissionLevel `json:"contents,omitempty" yaml:"contents,omitempty"`
 	Issues             PermissionLevel `json:"issues,omitempty" yaml:"issues,omitempty"`
 	Actions            PermissionLevel `json:"actions,omitempty" yaml:"actions,omitempty"`
+	Attestations       PermissionLevel `json:"attestations,omitempty" yaml:"attestations,omitempty"`
 	Packages           PermissionLevel `json:"packages,omitempty" yaml:"packages,omitempty"`
 	Deployments        PermissionLevel `json:"deployments,omitempty" yaml:"deployments,omitempty"`
 	PullRequests       PermissionLevel `json:"pull-requests,omitempty" yaml:"pull-requests,omitempty"`
@@ -141,4 +396,6 @@ type JobPermissions struct {
 	Metadata           PermissionLevel `json:"metadata,omitempty" yaml:"metadata,omitempty"`
 	Checks             PermissionLevel `json:"checks,omitempty" yaml:"checks,omitempty"`
 	Discussions        PermissionLevel `json:"discussions,omitempty" yaml:"discussions,omitempty"`
+	SecurityEvents     PermissionLevel `json:"security-events,omitempty" yaml:"security-events,omitempty"`
+	Models             PermissionLevel `json:"models,omitempty" yaml:"models,omitempty"`
 }
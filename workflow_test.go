@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestEnvMarshalYAML(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		// Expected serialization of the value, if it matters
+		want string
+	}{
+		{name: "plain", value: "hello", want: `"hello"`},
+		{name: "empty", value: "", want: `""`},
+		{name: "expression", value: "${{ secrets.TOKEN }}", want: `"${{ secrets.TOKEN }}"`},
+		{name: "dollar", value: "$HOME and $$", want: `"$HOME and $$"`},
+		{name: "double quotes", value: `say "hi"`, want: `"say \"hi\""`},
+		{name: "single quotes", value: "it's", want: `"it's"`},
+		{name: "backslashes", value: `C:\path\to\n`, want: `"C:\\path\\to\\n"`},
+		{name: "yaml special characters", value: "key: value # comment", want: `"key: value # comment"`},
+		{name: "boolean-like", value: "true", want: `"true"`},
+		{name: "number-like", value: "0123", want: `"0123"`},
+		{name: "leading space", value: "  indented"},
+		{name: "newlines", value: "line 1\nline 2"},
+		{name: "trailing newline", value: "line 1\nline 2\n"},
+		{name: "multi-line expression", value: "${{ github.event.comment.body }}\n${{ secrets.X }}"},
+		{name: "multi-line with quotes and backslashes", value: "echo \"a\\b\"\n'c'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contents, err := yaml.Marshal(Env{"VALUE": tt.value})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want != "" {
+				if got := strings.TrimSuffix(strings.TrimPrefix(string(contents), "VALUE: "), "\n"); got != tt.want {
+					t.Errorf("serialized as %s, want %s", got, tt.want)
+				}
+			}
+			var decoded map[string]any
+			if err := yaml.Unmarshal(contents, &decoded); err != nil {
+				t.Fatalf("invalid YAML %q: %s", contents, err)
+			}
+			if decoded["VALUE"] != tt.value {
+				t.Errorf("decoded as %q, want %q. YAML:\n%s", decoded["VALUE"], tt.value, contents)
+			}
+		})
+	}
+}